@@ -0,0 +1,111 @@
+package log
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ansiEscape matches ANSI CSI sequences such as color codes ("\x1b[31m") and cursor movements
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-?]*[ -/]*[@-~]")
+
+// ANSIStripWriter removes ANSI escape sequences from everything written through it. This lets a
+// colorizing formatter drive both a terminal and destinations that should stay plain, like files.
+type ANSIStripWriter struct {
+	w io.Writer
+}
+
+// NewANSIStripWriter creates an ANSIStripWriter writing to w
+func NewANSIStripWriter(w io.Writer) *ANSIStripWriter {
+	return &ANSIStripWriter{w: w}
+}
+
+func (w *ANSIStripWriter) Write(data []byte) (n int, err error) {
+	return w.WriteLevel(0, data)
+}
+
+// WriteLevel is Write for a message at the given level, which is passed on if the wrapped writer is a
+// LevelWriter
+func (w *ANSIStripWriter) WriteLevel(level int, data []byte) (n int, err error) {
+	_, err = writeLevel(w.w, level, ansiEscape.ReplaceAll(data, nil))
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flush flushes the wrapped writer if it implements Flusher
+func (w *ANSIStripWriter) Flush() error {
+	return flushWriter(w.w)
+}
+
+// Close closes the wrapped writer if it can be closed
+func (w *ANSIStripWriter) Close() error {
+	return closeInner(w.w)
+}
+
+// levelColors are the ANSI color codes of the log levels
+var levelColors = map[int]string{
+	LOG_LEVEL_TRACE: "\x1b[90m",
+	LOG_LEVEL_DEBUG: "\x1b[36m",
+	LOG_LEVEL_INFO:  "\x1b[32m",
+	LOG_LEVEL_WARN:  "\x1b[33m",
+	LOG_LEVEL_ERROR: "\x1b[31m",
+	LOG_LEVEL_FATAL: "\x1b[1;31m",
+}
+
+const colorReset = "\x1b[0m"
+
+// ColorFormatter colors the lines of another formatter by level, e.g. red for ERROR and yellow for
+// WARN. Lines are only colored when ForceColor is set or the ColorFormatter was created for a terminal
+// with NewColorFormatter, so colors never end up in files. To color a terminal and keep a file plain
+// with a single formatter, write to the file through an ANSIStripWriter.
+type ColorFormatter struct {
+	// Formatter formats the lines before coloring, DefaultLogFormatter if nil
+	Formatter LogFormatter
+	// ForceColor colors the lines whatever the destination
+	ForceColor bool
+
+	terminal bool
+}
+
+// NewColorFormatter creates a ColorFormatter that colors the lines of formatter if w is a terminal
+func NewColorFormatter(formatter LogFormatter, w io.Writer) *ColorFormatter {
+	return &ColorFormatter{Formatter: formatter, terminal: isTerminal(w)}
+}
+
+// isTerminal reports whether w is a file connected to a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (f *ColorFormatter) Format(t time.Time, level int, message string) string {
+	return f.FormatCaller(t, level, message, nil, "")
+}
+
+func (f *ColorFormatter) FormatFields(t time.Time, level int, message string, fields Fields) string {
+	return f.FormatCaller(t, level, message, fields, "")
+}
+
+func (f *ColorFormatter) FormatCaller(t time.Time, level int, message string, fields Fields, caller string) string {
+	var inner LogFormatter = &DefaultLogFormatter{}
+	if f.Formatter != nil {
+		inner = f.Formatter
+	}
+	line := formatWith(inner, t, level, message, fields, caller)
+	color, ok := levelColors[level]
+	if !ok || !(f.ForceColor || f.terminal) {
+		return line
+	}
+
+	// reset before the line ending, so the color doesn't spill into the next line
+	trimmed := strings.TrimRight(line, "\r\n")
+	return color + trimmed + colorReset + line[len(trimmed):]
+}
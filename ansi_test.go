@@ -0,0 +1,46 @@
+package log_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestANSIStripWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := log.NewANSIStripWriter(&buf)
+
+	colored := "\x1b[31mERROR\x1b[0m: \x1b[1;33mdisk\x1b[0m full\n"
+	n, err := w.Write([]byte(colored))
+	if err != nil || n != len(colored) {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if strings.Contains(buf.String(), "\x1b") {
+		t.Errorf("escape codes left in %q", buf.String())
+	}
+	if buf.String() != "ERROR: disk full\n" {
+		t.Errorf("stripped output = %q", buf.String())
+	}
+}
+
+func TestColorFormatter(t *testing.T) {
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	plain := log.NewColorFormatter(nil, &buf)
+	if line := plain.Format(ts, log.LOG_LEVEL_ERROR, "disk full"); strings.Contains(line, "\x1b") {
+		t.Errorf("colored a line for a buffer: %q", line)
+	}
+
+	forced := &log.ColorFormatter{ForceColor: true}
+	want := "\x1b[31mERROR: 2014-05-01T12:30:00 (UTC): disk full\x1b[0m\n"
+	if line := forced.Format(ts, log.LOG_LEVEL_ERROR, "disk full"); line != want {
+		t.Errorf("forced color formatted %q, want %q", line, want)
+	}
+	if line := forced.FormatFields(ts, log.LOG_LEVEL_WARN, "slow", log.Fields{"ms": 900}); !strings.HasPrefix(line, "\x1b[33m") || !strings.HasSuffix(line, "slow ms=900\x1b[0m\n") {
+		t.Errorf("forced color formatted %q", line)
+	}
+}
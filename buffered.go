@@ -0,0 +1,110 @@
+package log
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DEFAULT_BUFFER_SIZE is the buffer size of a BufferedWriter created with a size of 0
+const DEFAULT_BUFFER_SIZE = 32 << 10
+
+// BufferedWriter collects log lines in a buffer and writes them to the wrapped writer when the buffer is
+// full, on Flush and every flush interval, so a file gets one write syscall per buffer instead of one per
+// line. Lines written since the last flush are lost if the program crashes. Close flushes the buffer,
+// stops the periodic flushing and closes the wrapped writer if it can be closed.
+type BufferedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	buf   *bufio.Writer
+	stop  chan int
+	done  chan int
+}
+
+// NewBufferedWriter creates a BufferedWriter with a buffer of size bytes, DEFAULT_BUFFER_SIZE if 0,
+// flushing it to w every interval. An interval of 0 only flushes when the buffer is full or on Flush.
+func NewBufferedWriter(w io.Writer, size int, interval time.Duration) *BufferedWriter {
+	if size <= 0 {
+		size = DEFAULT_BUFFER_SIZE
+	}
+	bw := &BufferedWriter{w: w, buf: bufio.NewWriterSize(w, size)}
+	if interval > 0 {
+		bw.stop = make(chan int)
+		bw.done = make(chan int)
+		go bw.run(interval)
+	}
+	return bw
+}
+
+// run flushes the buffer every interval until the writer is closed
+func (w *BufferedWriter) run(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mutex.Lock()
+			if w.buf != nil {
+				w.buf.Flush()
+			}
+			w.mutex.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *BufferedWriter) Write(data []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.buf == nil {
+		return 0, os.ErrClosed
+	}
+	return w.buf.Write(data)
+}
+
+// Flush writes the buffered lines to the wrapped writer and flushes it if it implements Flusher
+func (w *BufferedWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.buf == nil {
+		return os.ErrClosed
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return flushWriter(w.w)
+}
+
+// Sync flushes the buffer and syncs the wrapped writer if it implements Syncer
+func (w *BufferedWriter) Sync() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return syncWriter(w.w)
+}
+
+// Close flushes the buffer, stops the periodic flushing and closes the wrapped writer if it can be
+// closed. Closing more than once is a no-op.
+func (w *BufferedWriter) Close() error {
+	w.mutex.Lock()
+	if w.buf == nil {
+		w.mutex.Unlock()
+		return nil
+	}
+	err := w.buf.Flush()
+	w.buf = nil
+	w.mutex.Unlock()
+
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	if e := closeInner(w.w); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package log_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestBufferedWriter(t *testing.T) {
+	rec := &lineRecorder{}
+	w := log.NewBufferedWriter(rec, 1024, 20*time.Millisecond)
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		logger.Infof("Message #%d", i)
+	}
+	if n := len(rec.Lines()); n != 0 {
+		t.Errorf("%d writes before the flush interval", n)
+	}
+
+	// the lines arrive in a single write within the interval
+	deadline := time.Now().Add(time.Second)
+	for len(rec.Lines()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if writes := rec.Lines(); len(writes) != 1 || strings.Count(writes[0], "\n") != 10 {
+		t.Errorf("got writes %q after the flush interval, want 10 lines in 1", writes)
+	}
+
+	logger.Info("flushed on close")
+	logger.Close()
+	if writes := rec.Lines(); len(writes) != 2 || !strings.HasSuffix(writes[1], ": flushed on close\n") {
+		t.Errorf("Close didn't flush the last line: %q", writes)
+	}
+	if _, err := w.Write([]byte("closed\n")); err != os.ErrClosed {
+		t.Errorf("Write after Close returned %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n >= goroutines {
+		t.Errorf("%d goroutines after Close, the flusher of %d is still running", n, goroutines)
+	}
+}
+
+func benchmarkFileLogger(b *testing.B, buffered bool) {
+	dir, err := ioutil.TempDir("", "log_buffered")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := os.Create(filepath.Join(dir, "app.log"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := log.New(file, log.LOG_LEVEL_INFO)
+	if buffered {
+		logger = log.New(log.NewBufferedWriter(file, 0, time.Second), log.LOG_LEVEL_INFO)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Info("This is a testing message.")
+	}
+	logger.Close()
+}
+
+func BenchmarkFileLogger(b *testing.B) {
+	benchmarkFileLogger(b, false)
+}
+
+func BenchmarkBufferedFileLogger(b *testing.B) {
+	benchmarkFileLogger(b, true)
+}
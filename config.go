@@ -0,0 +1,150 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LogConfig declares a logger, e.g. as loaded from a JSON or YAML configuration file. The zero value
+// logs INFO messages in the default text format to stderr.
+type LogConfig struct {
+	// Level is the minimum log level name, like "DEBUG" or "warn"; INFO if empty
+	Level string `json:"level" yaml:"level"`
+	// Format is "text" (the default), "json" or "logfmt"
+	Format string `json:"format" yaml:"format"`
+	// Output is "stderr" (the default), "stdout" or "file"
+	Output string `json:"output" yaml:"output"`
+
+	// Path is the directory of the log file, required for file output
+	Path string `json:"path" yaml:"path"`
+	// Name is the log file name without extension, the program name if empty
+	Name string `json:"name" yaml:"name"`
+	// MaxBytes rotates the log file when it would grow beyond this size, 0 disables size rotation
+	MaxBytes int64 `json:"max_bytes" yaml:"max_bytes"`
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int `json:"max_backups" yaml:"max_backups"`
+	// Daily starts a new log file every day, it can't be combined with MaxBytes
+	Daily bool `json:"daily" yaml:"daily"`
+
+	// Async writes messages from a background goroutine through a queue of BufferSize messages,
+	// DEFAULT_QUEUE_SIZE if 0
+	Async      bool `json:"async" yaml:"async"`
+	BufferSize int  `json:"buffer_size" yaml:"buffer_size"`
+}
+
+// validate checks the configuration and returns its log level
+func (cfg *LogConfig) validate() (int, error) {
+	var errs []string
+	level := LOG_LEVEL_INFO
+	if cfg.Level != "" {
+		if level = String2LogLevel(cfg.Level); level < 0 {
+			errs = append(errs, fmt.Sprintf("unknown level %q", cfg.Level))
+		}
+	}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text", "json", "logfmt":
+	default:
+		errs = append(errs, fmt.Sprintf("unknown format %q, want text, json or logfmt", cfg.Format))
+	}
+
+	file := strings.ToLower(cfg.Output) == "file"
+	switch strings.ToLower(cfg.Output) {
+	case "", "stderr", "stdout", "file":
+	default:
+		errs = append(errs, fmt.Sprintf("unknown output %q, want stderr, stdout or file", cfg.Output))
+	}
+	if file && cfg.Path == "" {
+		errs = append(errs, "file output needs a path")
+	}
+	if !file && (cfg.Path != "" || cfg.Name != "" || cfg.MaxBytes != 0 || cfg.MaxBackups != 0 || cfg.Daily) {
+		errs = append(errs, "path, name and rotation settings need file output")
+	}
+	if cfg.MaxBytes < 0 || cfg.MaxBackups < 0 {
+		errs = append(errs, "max_bytes and max_backups must not be negative")
+	}
+	if cfg.Daily && cfg.MaxBytes > 0 {
+		errs = append(errs, "daily and size rotation can't be combined")
+	}
+	if cfg.BufferSize < 0 || (cfg.BufferSize > 0 && !cfg.Async) {
+		errs = append(errs, "buffer_size must be positive and needs async")
+	}
+
+	if len(errs) > 0 {
+		return 0, fmt.Errorf("log: invalid config: %s", strings.Join(errs, "; "))
+	}
+	return level, nil
+}
+
+// unclosable keeps closing the logger from closing the standard streams. Flush and Sync still reach the
+// stream.
+type unclosable struct {
+	io.Writer
+}
+
+func (w unclosable) Flush() error {
+	return flushWriter(w.Writer)
+}
+
+func (w unclosable) Sync() error {
+	return syncWriter(w.Writer)
+}
+
+// unwrapStream returns the stream wrapped by unclosable, or w itself
+func unwrapStream(w io.Writer) io.Writer {
+	if u, ok := w.(unclosable); ok {
+		return u.Writer
+	}
+	return w
+}
+
+// NewFromConfig creates a logger as declared by cfg. All problems of the configuration are reported
+// together, before any file is opened.
+func NewFromConfig(cfg LogConfig) (*Logger, error) {
+	level, err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer
+	var fname string
+	switch strings.ToLower(cfg.Output) {
+	case "stdout":
+		w = unclosable{os.Stdout}
+	case "file":
+		var filepath string
+		fname, filepath, err = prepareLogFile(cfg.Path, cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case cfg.Daily:
+			// days change at midnight UTC, like the timestamps of DefaultLogFormatter
+			w, err = NewDailyFileWriter(cfg.Path, fname, true)
+		case cfg.MaxBytes > 0:
+			w, err = NewRotatingFileWriter(filepath, cfg.MaxBytes, cfg.MaxBackups)
+		default:
+			w, err = os.OpenFile(filepath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, DEFAULT_FILE_MODE)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		w = unclosable{os.Stderr}
+	}
+	if cfg.Async {
+		w = NewAsyncLogWriter(w, cfg.BufferSize)
+	}
+
+	logger := New(w, level)
+	logger.path = cfg.Path
+	logger.fname = fname
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		logger.SetFormatter(&JSONFormatter{})
+	case "logfmt":
+		logger.SetFormatter(&LogfmtFormatter{})
+	}
+	return logger, nil
+}
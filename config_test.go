@@ -0,0 +1,58 @@
+package log_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "."
+)
+
+func TestNewFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cfg log.LogConfig
+	data := `{"level":"debug","format":"json","output":"file","path":"` + dir + `","name":"svc","max_bytes":1024,"max_backups":1,"async":true}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	logger, err := log.NewFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Trace("filtered")
+	logger.Debug("configured")
+	logger.Close()
+
+	out, err := ioutil.ReadFile(filepath.Join(dir, "svc.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"level":"DEBUG","message":"configured"`) {
+		t.Errorf("log file contains %q", out)
+	}
+}
+
+func TestNewFromConfigValidation(t *testing.T) {
+	_, err := log.NewFromConfig(log.LogConfig{Level: "loud", Format: "xml", Output: "file", Daily: true, MaxBytes: 10})
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, want := range []string{`unknown level "loud"`, `unknown format "xml"`, "file output needs a path", "daily and size rotation"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+
+	logger, err := log.NewFromConfig(log.LogConfig{})
+	if err != nil || logger.Config().Level != log.LOG_LEVEL_INFO {
+		t.Errorf("zero config: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ContextExtractor returns the fields to log for the values of a context, e.g. the trace id of a request
+type ContextExtractor func(ctx context.Context) Fields
+
+// AddContextExtractor registers a function mapping context values to fields. The *Context methods add
+// the fields of every registered extractor to their message. Child loggers created afterwards with
+// WithFields or Dup inherit the extractors.
+func (logger *Logger) AddContextExtractor(extractor ContextExtractor) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	// copy the slice, it may be shared with child loggers
+	logger.extractors = append(logger.extractors[:len(logger.extractors):len(logger.extractors)], extractor)
+}
+
+// contextFields returns the fields extracted from ctx, nil if ctx is nil
+func (logger *Logger) contextFields(ctx context.Context) Fields {
+	if ctx == nil {
+		return nil
+	}
+	logger.mutex.Lock()
+	extractors := logger.extractors
+	logger.mutex.Unlock()
+
+	var fields Fields
+	for _, extract := range extractors {
+		for k, v := range extract(ctx) {
+			if fields == nil {
+				fields = Fields{}
+			}
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// LogContext logs a message at the given log level with the fields extracted from ctx. ctx may be nil.
+func (logger *Logger) LogContext(ctx context.Context, loglevel int, v ...interface{}) {
+	fields := logger.contextFields(ctx)
+	if logger.enabledFields(loglevel, fields) {
+		logger.emitFields(time.Now(), loglevel, fmt.Sprint(v...), fields, "")
+	}
+}
+
+// DebugContext logs a message at log level: LOG_LEVEL_DEBUG with the fields extracted from ctx
+func (logger *Logger) DebugContext(ctx context.Context, v ...interface{}) {
+	logger.LogContext(ctx, LOG_LEVEL_DEBUG, v...)
+}
+
+// InfoContext logs a message at log level: LOG_LEVEL_INFO with the fields extracted from ctx
+func (logger *Logger) InfoContext(ctx context.Context, v ...interface{}) {
+	logger.LogContext(ctx, LOG_LEVEL_INFO, v...)
+}
+
+// WarnContext logs a message at log level: LOG_LEVEL_WARN with the fields extracted from ctx
+func (logger *Logger) WarnContext(ctx context.Context, v ...interface{}) {
+	logger.LogContext(ctx, LOG_LEVEL_WARN, v...)
+}
+
+// ErrorContext logs a message at log level: LOG_LEVEL_ERROR with the fields extracted from ctx
+func (logger *Logger) ErrorContext(ctx context.Context, v ...interface{}) {
+	logger.LogContext(ctx, LOG_LEVEL_ERROR, v...)
+}
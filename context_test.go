@@ -0,0 +1,40 @@
+package log_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	log "."
+)
+
+type traceKey struct{}
+
+func TestLogContext(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	logger.AddContextExtractor(func(ctx context.Context) log.Fields {
+		if id, ok := ctx.Value(traceKey{}).(string); ok {
+			return log.Fields{"trace_id": id}
+		}
+		return nil
+	})
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "abc123")
+	logger.InfoContext(ctx, "handled")
+	logger.WithFields(log.Fields{"user": "bob"}).ErrorContext(ctx, "failed")
+	logger.WarnContext(context.Background(), "no trace")
+	logger.InfoContext(nil, "nil context")
+	logger.DebugContext(ctx, "filtered")
+
+	lines := rec.Lines()
+	want := []string{": handled trace_id=abc123\n", ": failed trace_id=abc123 user=bob\n", ": no trace\n", ": nil context\n"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(want), lines)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %q doesn't end with %q", line, want[i])
+		}
+	}
+}
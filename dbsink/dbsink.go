@@ -0,0 +1,250 @@
+// Package dbsink writes log messages as rows into a database table, e.g. a local SQLite database used as
+// a self-contained audit log. It only uses database/sql; the program picks and registers the driver, so
+// neither the core log package nor this one depend on a specific database.
+package dbsink
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiddle/log"
+)
+
+// DEFAULT_BATCH_SIZE is the maximum number of rows NewWriter inserts in one transaction
+const DEFAULT_BATCH_SIZE = 100
+
+// DEFAULT_FLUSH_INTERVAL is the longest time NewWriter keeps rows before inserting them
+const DEFAULT_FLUSH_INTERVAL = time.Second
+
+// tableName matches the table names InsertStatement accepts: an identifier, optionally qualified by a
+// schema, so the name can't change the statement it is put into
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// InsertStatement returns the statement inserting a row into table, which must have the columns
+// time, level, message and fields. It uses ? placeholders, as SQLite and MySQL do. Table names other than
+// plain identifiers like audit or logs.audit are rejected.
+func InsertStatement(table string) (string, error) {
+	if !tableName.MatchString(table) {
+		return "", fmt.Errorf("dbsink: invalid table name %q", table)
+	}
+	return fmt.Sprintf("INSERT INTO %s (time, level, message, fields) VALUES (?, ?, ?, ?)", table), nil
+}
+
+// Writer inserts log messages into a database, one row per message with the time, level name, message
+// and the fields as a JSON object, or NULL if there are none. It is a log.RecordWriter, so the rows are
+// built from the messages themselves, whatever the formatter and prefix of the logger. Rows are inserted
+// in batches, one transaction per batch: when a batch is full, in the logging goroutine, every flush
+// interval, on Flush and on Close. Closing the Writer doesn't close the database.
+type Writer struct {
+	db        *sql.DB
+	query     string
+	batchSize int
+	onError   atomic.Value // holds a log.ErrorHandler
+
+	mutex  sync.Mutex
+	batch  []log.LogRecord
+	closed bool
+	stop   chan int
+	done   chan int
+}
+
+// NewWriter creates a Writer inserting into table with InsertStatement, in batches of up to
+// DEFAULT_BATCH_SIZE rows at least every DEFAULT_FLUSH_INTERVAL
+func NewWriter(db *sql.DB, table string) (*Writer, error) {
+	query, err := InsertStatement(table)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterWithStatement(db, query, DEFAULT_BATCH_SIZE, DEFAULT_FLUSH_INTERVAL), nil
+}
+
+// NewWriterWithStatement creates a Writer executing query for every message, with the time, level,
+// message and fields as arguments. Use it for databases with other placeholders or column names. Up to
+// batchSize rows are inserted together, and kept at most flushInterval; a batchSize of 1 or less inserts
+// every message right away, and a flushInterval of 0 only inserts full batches and on Flush.
+func NewWriterWithStatement(db *sql.DB, query string, batchSize int, flushInterval time.Duration) *Writer {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	w := &Writer{db: db, query: query, batchSize: batchSize}
+	if batchSize > 1 && flushInterval > 0 {
+		w.stop = make(chan int)
+		w.done = make(chan int)
+		go w.run(flushInterval)
+	}
+	return w
+}
+
+// NewLogger creates a logger inserting its messages into table, in batches of up to DEFAULT_BATCH_SIZE
+// rows
+func NewLogger(db *sql.DB, table string, loglevel int) (*log.Logger, error) {
+	w, err := NewWriter(db, table)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(w, loglevel), nil
+}
+
+// run inserts the pending rows every interval until the writer is closed
+func (w *Writer) run(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mutex.Lock()
+			err := w.insert()
+			w.mutex.Unlock()
+			if err != nil {
+				w.failed(err)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// SetErrorHandler sets a function called with the error of every failed insert of the periodic
+// flushing, which has no caller to return it to. The logger passes its own handler on, see
+// log.Logger.SetErrorHandler.
+func (w *Writer) SetErrorHandler(handler log.ErrorHandler) {
+	w.onError.Store(handler)
+}
+
+// failed passes an insert error to the error handler, if any
+func (w *Writer) failed(err error) {
+	if handler, _ := w.onError.Load().(log.ErrorHandler); handler != nil {
+		handler(err)
+	}
+}
+
+// WriteRecord adds a row for the message to the batch, and inserts the batch if it is full
+func (w *Writer) WriteRecord(r log.LogRecord) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.batch = append(w.batch, r)
+	if len(w.batch) < w.batchSize {
+		return nil
+	}
+	return w.insert()
+}
+
+// Write adds a row for every line of data, with the current time and neither level nor fields. The
+// logger only uses it for messages without a record; it makes the Writer usable as a plain io.Writer,
+// e.g. for the standard log package.
+func (w *Writer) Write(data []byte) (n int, err error) {
+	now := time.Now()
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := w.WriteRecord(log.LogRecord{Time: now, Message: string(line)}); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// insert inserts the batch in one transaction. A batch that fails is discarded, like a message that
+// can't be written. The mutex must be held.
+func (w *Writer) insert() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	batch := w.batch
+	w.batch = w.batch[:0]
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(w.query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range batch {
+		if _, err := stmt.Exec(r.Time, levelName(r.Level), r.Message, fieldsJSON(r.Fields)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Flush inserts the pending rows
+func (w *Writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	return w.insert()
+}
+
+// Sync inserts the pending rows, the database commits them to stable storage
+func (w *Writer) Sync() error {
+	return w.Flush()
+}
+
+// Close inserts the pending rows and stops the periodic flushing. Closing more than once is a no-op.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	err := w.insert()
+	w.closed = true
+	w.mutex.Unlock()
+
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	return err
+}
+
+// levelName returns the name of the level, or nil for messages without a level
+func levelName(level int) interface{} {
+	if level == 0 {
+		return nil
+	}
+	return log.LogLevel2String(level)
+}
+
+// fieldsJSON returns the fields as a JSON object, or nil if there are none. Errors are written as their
+// message, and values that can't be encoded as JSON as their string form.
+func fieldsJSON(fields log.Fields) interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	values := make(map[string]json.RawMessage, len(fields))
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(v))
+		}
+		values[k] = data
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
@@ -0,0 +1,193 @@
+package dbsink_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiddle/log"
+	"github.com/gofiddle/log/dbsink"
+)
+
+// fakeDriver opens a fakeDB per data source name
+type fakeDriver struct {
+	mutex sync.Mutex
+	dbs   map[string]*fakeDB
+}
+
+var fake = &fakeDriver{dbs: map[string]*fakeDB{}}
+
+func init() {
+	sql.Register("fakedb", fake)
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.dbs[name] == nil {
+		d.dbs[name] = &fakeDB{}
+	}
+	return d.dbs[name], nil
+}
+
+// openFake opens a database/sql handle on a fresh fakeDB
+func openFake(t *testing.T) (*sql.DB, *fakeDB) {
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := fake.Open(t.Name())
+	f := conn.(*fakeDB)
+	f.mutex.Lock()
+	f.rows, f.queries, f.commits = nil, nil, 0
+	f.mutex.Unlock()
+	return db, f
+}
+
+// fakeDB is a database/sql driver connection recording the rows inserted by committed transactions
+type fakeDB struct {
+	mutex   sync.Mutex
+	queries []string
+	rows    [][]driver.Value
+	commits int
+	pending [][]driver.Value
+}
+
+func (db *fakeDB) Close() error              { return nil }
+func (db *fakeDB) Begin() (driver.Tx, error) { return db, nil }
+
+func (db *fakeDB) Prepare(query string) (driver.Stmt, error) {
+	db.mutex.Lock()
+	db.queries = append(db.queries, query)
+	db.mutex.Unlock()
+	return fakeStmt{db}, nil
+}
+
+func (db *fakeDB) Commit() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.rows = append(db.rows, db.pending...)
+	db.pending = nil
+	db.commits++
+	return nil
+}
+
+func (db *fakeDB) Rollback() error {
+	db.mutex.Lock()
+	db.pending = nil
+	db.mutex.Unlock()
+	return nil
+}
+
+type fakeStmt struct {
+	db *fakeDB
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return 4 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mutex.Lock()
+	s.db.pending = append(s.db.pending, args)
+	s.db.mutex.Unlock()
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestDBWriter(t *testing.T) {
+	db, fake := openFake(t)
+	defer db.Close()
+
+	logger, err := dbsink.NewLogger(db, "audit", log.LOG_LEVEL_INFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rows don't depend on how the logger formats its messages
+	logger.SetPrefix("audit: ")
+	logger.SetFormatter(&log.LogfmtFormatter{})
+	for i := 0; i < 5; i++ {
+		logger.WithFields(log.Fields{"user": "john", "n": i}).Info("login")
+	}
+	logger.WithFields(log.Fields{"err": errors.New("denied")}).Warn("login failed")
+	logger.Warn("no fields")
+	logger.Close()
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if len(fake.rows) != 7 {
+		t.Fatalf("%d rows inserted, want 7", len(fake.rows))
+	}
+	if fake.commits != 1 {
+		t.Errorf("%d transactions for 7 rows, want them batched", fake.commits)
+	}
+	if fake.queries[0] != "INSERT INTO audit (time, level, message, fields) VALUES (?, ?, ?, ?)" {
+		t.Errorf("query = %q", fake.queries[0])
+	}
+
+	first, failed, last := fake.rows[0], fake.rows[5], fake.rows[6]
+	if _, ok := first[0].(time.Time); !ok || first[1] != "INFO" || first[2] != "login" || first[3] != `{"n":0,"user":"john"}` {
+		t.Errorf("first row = %v", first)
+	}
+	if failed[3] != `{"err":"denied"}` {
+		t.Errorf("error field stored as %v", failed[3])
+	}
+	if last[1] != "WARN" || last[2] != "no fields" || last[3] != nil {
+		t.Errorf("last row = %v", last)
+	}
+}
+
+func TestDBWriterBatches(t *testing.T) {
+	db, fake := openFake(t)
+	defer db.Close()
+
+	w := dbsink.NewWriterWithStatement(db, "INSERT INTO audit VALUES ($1, $2, $3, $4)", 2, 10*time.Millisecond)
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	logger.Info("one")
+	logger.Info("two")
+	logger.Info("three")
+
+	// the full batch is inserted right away, the rest within the flush interval
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mutex.Lock()
+		rows, commits := len(fake.rows), fake.commits
+		fake.mutex.Unlock()
+		if rows == 3 {
+			if commits != 2 {
+				t.Errorf("%d transactions, want 2", commits)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d rows inserted, want 3", rows)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	logger.Close()
+	if err := w.WriteRecord(log.LogRecord{Level: log.LOG_LEVEL_INFO, Message: "late"}); err == nil {
+		t.Error("WriteRecord after Close succeeded")
+	}
+}
+
+func TestInsertStatementTable(t *testing.T) {
+	for _, table := range []string{"audit", "logs.audit", "_audit2"} {
+		if _, err := dbsink.InsertStatement(table); err != nil {
+			t.Errorf("InsertStatement(%q): %v", table, err)
+		}
+	}
+	for _, table := range []string{"", "audit; DROP TABLE users", "audit (time)", "1audit", "a.b.c", `"audit"`} {
+		if _, err := dbsink.InsertStatement(table); err == nil {
+			t.Errorf("InsertStatement(%q) accepted the name", table)
+		}
+	}
+	if _, err := dbsink.NewLogger(nil, "audit--", log.LOG_LEVEL_INFO); err == nil {
+		t.Error("NewLogger accepted an invalid table name")
+	}
+}
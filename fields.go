@@ -0,0 +1,213 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Fields are key/value pairs attached to log messages, like a request or user id
+type Fields map[string]interface{}
+
+// FieldsFormatter is implemented by formatters that render fields themselves. The logger calls
+// FormatFields instead of Format whenever a message has fields; for formatters that don't implement it,
+// the fields are appended to the message as key=value pairs.
+type FieldsFormatter interface {
+	LogFormatter
+	FormatFields(t time.Time, level int, message string, fields Fields) string
+}
+
+// CallerFormatter is implemented by formatters that render the caller reported by SetReportCaller
+// themselves. For formatters that don't implement it, the caller is appended to the message as
+// " (file.go:42)".
+type CallerFormatter interface {
+	LogFormatter
+	FormatCaller(t time.Time, level int, message string, fields Fields, caller string) string
+}
+
+// WithFields returns a child logger adding the given fields, merged with the logger's own fields, to every
+// message. The child writes to the same writer under the same mutex as its parent, but it doesn't own
+// the writer: closing the child doesn't close the writer.
+func (logger *Logger) WithFields(fields Fields) *Logger {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	child := &Logger{
+		mutex:         logger.mutex,
+		guard:         logger.guard,
+		level:         logger.childLevel(),
+		inherit:       logger.inherit,
+		syncLevel:     atomic.LoadInt32(&logger.syncLevel),
+		path:          logger.path,
+		fname:         logger.fname,
+		writer:        logger.writer,
+		formatter:     logger.formatter,
+		dumpStacks:    logger.dumpStacks,
+		stackDepth:    logger.stackDepth,
+		brokenPipe:    logger.brokenPipe,
+		fields:        mergeFields(logger.fields, fields),
+		dynamicFields: logger.dynamicFields,
+		reportCaller:  logger.reportCaller,
+		onError:       logger.onError,
+		expandDepth:   logger.expandDepth,
+		writeTimeout:  logger.writeTimeout,
+		extractors:    logger.extractors,
+		sampler:       logger.sampler,
+		sampleLevel:   logger.sampleLevel,
+		limiter:       logger.limiter,
+		prefix:        logger.prefix,
+		bufferSize:    logger.bufferSize,
+		taps:          logger.taps,
+		hooks:         logger.hooks,
+	}
+	child.verbose.Store(logger.verboseField())
+	return child
+}
+
+// ERROR_FIELD is the field WithError stores the error in
+const ERROR_FIELD = "error"
+
+// STACK_FIELD is the field holding the stack trace of the error of an ERROR or FATAL message
+const STACK_FIELD = "stack"
+
+// WithError returns a child logger adding err to every message as the "error" field. A nil error adds
+// nothing, WithError returns the logger itself then. If the error carries a stack trace, printed with
+// the %+v verb like the errors of github.com/pkg/errors, ERROR and FATAL messages get it as the "stack"
+// field too.
+func (logger *Logger) WithError(err error) *Logger {
+	if err == nil {
+		return logger
+	}
+	return logger.WithFields(Fields{ERROR_FIELD: err})
+}
+
+// withErrorStack adds the stack trace of the error field, if any, to a copy of fields
+func withErrorStack(fields Fields) Fields {
+	err, ok := fields[ERROR_FIELD].(error)
+	if !ok {
+		return fields
+	}
+	msg := err.Error()
+	detailed := fmt.Sprintf("%+v", err)
+	if detailed == msg || !strings.HasPrefix(detailed, msg) {
+		return fields
+	}
+	return mergeFields(fields, Fields{STACK_FIELD: strings.TrimLeft(detailed[len(msg):], "\n")})
+}
+
+// SetDynamicFields sets a function providing fields that are computed anew for every message written,
+// e.g. the current tenant or a rotating correlation id. They are merged with the logger's fields.
+func (logger *Logger) SetDynamicFields(provider func() map[string]interface{}) {
+	logger.mutex.Lock()
+	logger.dynamicFields = provider
+	logger.mutex.Unlock()
+}
+
+// SetExpandStructs makes the logger flatten struct field values into one field per exported struct
+// field, named "key.field", instead of writing them as a single value. A `log:"name"` tag renames a
+// struct field and `log:"-"` leaves it out. Nested structs are expanded up to maxDepth levels; 0
+// disables the expansion. Values implementing error or fmt.Stringer, like time.Time, are kept whole.
+func (logger *Logger) SetExpandStructs(maxDepth int) {
+	logger.mutex.Lock()
+	logger.expandDepth = maxDepth
+	logger.mutex.Unlock()
+}
+
+// expandStructs returns the fields with struct values flattened up to depth levels
+func expandStructs(fields Fields, depth int) Fields {
+	expanded := make(Fields, len(fields))
+	for k, v := range fields {
+		expandValue(expanded, k, v, depth)
+	}
+	return expanded
+}
+
+// expandValue adds v to fields under key, flattening it if it is a struct or a pointer to one
+func expandValue(fields Fields, key string, v interface{}, depth int) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch v.(type) {
+	case error, fmt.Stringer:
+		fields[key] = v
+		return
+	}
+	if depth <= 0 || rv.Kind() != reflect.Struct {
+		fields[key] = v
+		return
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		name := field.Name
+		tag := field.Tag.Get("log")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			name = tag
+		} else if field.Anonymous {
+			// embedded structs are flattened into their parent
+			if fv := rv.Field(i); fv.CanInterface() {
+				expandValue(fields, key, fv.Interface(), depth)
+			}
+			continue
+		}
+		if fv := rv.Field(i); fv.CanInterface() {
+			expandValue(fields, key+"."+name, fv.Interface(), depth-1)
+		}
+	}
+}
+
+// mergeFields returns a new map holding the fields of a overridden by those of b
+func mergeFields(a, b Fields) Fields {
+	merged := make(Fields, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
+
+// sortedKeys returns the keys of the fields in sorted order
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendFields appends the fields sorted by key as key=value pairs to the message, keeping a trailing
+// newline at the end
+func appendFields(message string, fields Fields) string {
+	if len(fields) == 0 {
+		return message
+	}
+
+	var buf bytes.Buffer
+	writeMessageFields(&buf, message, fields)
+	return buf.String()
+}
+
+// writeMessageFields writes the message to buf with the fields appended as key=value pairs sorted by key,
+// before the trailing newline of the message if it has one
+func writeMessageFields(buf *bytes.Buffer, message string, fields Fields) {
+	trimmed := strings.TrimSuffix(message, "\n")
+	buf.WriteString(trimmed)
+	for _, k := range sortedKeys(fields) {
+		fmt.Fprintf(buf, " %s=%v", k, fields[k])
+	}
+	buf.WriteString(message[len(trimmed):])
+}
@@ -0,0 +1,199 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+
+	fields := log.Fields{"request_id": "r1"}
+	child := logger.WithFields(fields)
+	grandchild := child.WithFields(log.Fields{"user_id": 7, "request_id": "r2"})
+	fields["request_id"] = "changed" // the child keeps its own copy
+
+	logger.Info("parent")
+	child.Info("child")
+	grandchild.Info("grandchild")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3 on the shared writer: %q", len(lines), lines)
+	}
+	if !strings.HasSuffix(lines[0], ": parent") {
+		t.Errorf("parent line has fields: %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], ": child request_id=r1") {
+		t.Errorf("unexpected child line: %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], ": grandchild request_id=r2 user_id=7") {
+		t.Errorf("unexpected grandchild line: %q", lines[2])
+	}
+}
+
+func TestJSONFormatterFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	logger.SetFormatter(&log.JSONFormatter{})
+
+	logger.Info("no fields")
+	logger.WithFields(log.Fields{"user_id": 7, "name": "bob"}).Info("with fields")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var plain, structured struct {
+		Message string                 `json:"message"`
+		Fields  map[string]interface{} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &plain); err != nil || strings.Contains(lines[0], `"fields"`) {
+		t.Errorf("unexpected line without fields: %q (%v)", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &structured); err != nil {
+		t.Fatalf("invalid JSON %q: %v", lines[1], err)
+	}
+	if structured.Fields["user_id"] != 7.0 || structured.Fields["name"] != "bob" {
+		t.Errorf("unexpected fields: %v", structured.Fields)
+	}
+}
+
+type address struct {
+	City string `log:"city"`
+	Zip  string
+}
+
+type user struct {
+	ID       int    `log:"user_id"`
+	Password string `log:"-"`
+	Address  address
+	Created  time.Time
+	internal int
+}
+
+func TestExpandStructs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	u := &user{ID: 7, Password: "secret", Address: address{City: "Berlin", Zip: "10115"}, internal: 1}
+
+	logger.SetExpandStructs(2)
+	logger.WithFields(log.Fields{"user": u}).Info("login")
+	want := "login user.Address.Zip=10115 user.Address.city=Berlin user.Created=0001-01-01 00:00:00 +0000 UTC user.user_id=7\n"
+	if !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("logged %q, want it to end with %q", buf.String(), want)
+	}
+
+	// nested structs beyond the depth limit are kept whole
+	buf.Reset()
+	logger.SetExpandStructs(1)
+	logger.WithFields(log.Fields{"user": u}).Info("login")
+	if !strings.Contains(buf.String(), " user.Address={Berlin 10115} ") || strings.Contains(buf.String(), "secret") {
+		t.Errorf("logged %q", buf.String())
+	}
+}
+
+func TestVerboseField(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	logger.SetVerboseField("sampled", true, log.LOG_LEVEL_DEBUG)
+
+	logger.Debug("plain detail")
+	logger.WithFields(log.Fields{"sampled": false}).Debug("unsampled detail")
+	logger.WithFields(log.Fields{"sampled": true}).Debug("sampled detail")
+	logger.LogRecord(log.LogRecord{Level: log.LOG_LEVEL_DEBUG, Message: "sampled record", Fields: log.Fields{"sampled": true}})
+	logger.Info("info")
+
+	got := buf.String()
+	if strings.Contains(got, "plain detail") || strings.Contains(got, "unsampled detail") {
+		t.Errorf("DEBUG logged without the sampled field: %q", got)
+	}
+	if !strings.Contains(got, "sampled detail") || !strings.Contains(got, "sampled record") || !strings.Contains(got, "info") {
+		t.Errorf("sampled DEBUG or INFO messages missing: %q", got)
+	}
+}
+
+// stackError prints a stack trace with %+v, like the errors of github.com/pkg/errors
+type stackError struct{}
+
+func (stackError) Error() string { return "boom" }
+
+func (e stackError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprint(s, "boom\nmain.run\n\tmain.go:42")
+		return
+	}
+	fmt.Fprint(s, e.Error())
+}
+
+func TestWithError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	if logger.WithError(nil) != logger {
+		t.Error("WithError(nil) isn't a no-op")
+	}
+	logger.WithError(nil).Info("no error")
+	if strings.Contains(buf.String(), "error=") {
+		t.Errorf("nil error added a field: %q", buf.String())
+	}
+
+	buf.Reset()
+	wrapped := fmt.Errorf("saving user: %w", errors.New("disk full"))
+	logger.WithError(wrapped).Error("failed")
+	if !strings.HasSuffix(buf.String(), ": failed error=saving user: disk full\n") {
+		t.Errorf("wrapped error logged as %q", buf.String())
+	}
+
+	// stack traces are added to ERROR messages only
+	buf.Reset()
+	logger.SetFormatter(&log.JSONFormatter{})
+	child := logger.WithError(stackError{})
+	child.Warn("warning")
+	child.Error("error")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var warn, fail struct{ Fields map[string]interface{} }
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &warn) != nil || json.Unmarshal([]byte(lines[1]), &fail) != nil {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	if warn.Fields["error"] != "boom" || warn.Fields["stack"] != nil {
+		t.Errorf("WARN message has fields %v", warn.Fields)
+	}
+	if fail.Fields["error"] != "boom" || fail.Fields["stack"] != "main.run\n\tmain.go:42" {
+		t.Errorf("ERROR message has fields %v", fail.Fields)
+	}
+}
+
+func TestInheritLevel(t *testing.T) {
+	var buf bytes.Buffer
+	parent := log.New(&buf, log.LOG_LEVEL_INFO)
+	own := parent.WithFields(log.Fields{"own": true})
+	parent.SetInheritLevel(true)
+	child := parent.WithFields(log.Fields{"child": true})
+	grandchild := child.WithFields(log.Fields{"grandchild": true})
+
+	parent.SetLogLevel(log.LOG_LEVEL_ERROR)
+	for _, l := range []*log.Logger{child, grandchild} {
+		if level := l.GetLevel(); level != log.LOG_LEVEL_ERROR {
+			t.Errorf("descendant level = %d, want the parent's LOG_LEVEL_ERROR", level)
+		}
+	}
+	if level := own.GetLevel(); level != log.LOG_LEVEL_INFO {
+		t.Errorf("child created before SetInheritLevel has level %d, want its own LOG_LEVEL_INFO", level)
+	}
+
+	// any logger sharing the level changes it for all of them
+	grandchild.SetLogLevel(log.LOG_LEVEL_DEBUG)
+	grandchild.Debug("debug")
+	parent.Debug("debug")
+	if n := strings.Count(buf.String(), "DEBUG: "); n != 2 {
+		t.Errorf("%d debug messages written, want 2: %q", n, buf.String())
+	}
+	if parent.LevelVar() != grandchild.LevelVar() {
+		t.Error("grandchild doesn't share the LevelVar of the parent")
+	}
+}
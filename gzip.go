@@ -0,0 +1,108 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+)
+
+// GzipFileWriter writes a gzip compressed log file. The compressor buffers data, Flush and Sync write
+// it out to the file; Close, which the logger also calls on Fatal and Panic, finishes the gzip stream so
+// no tail of the log is lost. Appending to an existing file adds another gzip member, which gunzip and
+// gzip.Reader read as one stream.
+type GzipFileWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+	gz    *gzip.Writer
+}
+
+// NewGzipFileWriter creates a GzipFileWriter compressing to file. Closing the writer closes the file.
+func NewGzipFileWriter(file *os.File) *GzipFileWriter {
+	return &GzipFileWriter{file: file, gz: gzip.NewWriter(file)}
+}
+
+// NewGzipFileLogger creates a new logger which writes gzip compressed logs to <fname>.log.gz in logpath
+func NewGzipFileLogger(logpath string, fname string, loglevel int) (*Logger, error) {
+	fname, filepath, err := prepareLogFile(logpath, fname)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath+".gz", os.O_CREATE|os.O_WRONLY|os.O_APPEND, DEFAULT_FILE_MODE)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := New(NewGzipFileWriter(file), loglevel)
+	logger.path = logpath
+	logger.fname = fname
+	return logger, nil
+}
+
+func (w *GzipFileWriter) Write(data []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.gz == nil {
+		return 0, os.ErrClosed
+	}
+	return w.gz.Write(data)
+}
+
+// Flush writes the compressed data buffered so far to the file
+func (w *GzipFileWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.gz == nil {
+		return os.ErrClosed
+	}
+	return w.gz.Flush()
+}
+
+// Sync flushes the compressed data and commits the file to stable storage
+func (w *GzipFileWriter) Sync() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close finishes the gzip stream and closes the file
+func (w *GzipFileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	if e := w.file.Close(); err == nil {
+		err = e
+	}
+	w.gz = nil
+	return err
+}
+
+// gzipFile compresses the file src into dst and removes src
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, DEFAULT_FILE_MODE)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if e := gz.Close(); err == nil {
+		err = e
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
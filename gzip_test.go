@@ -0,0 +1,91 @@
+package log_test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "."
+)
+
+// gunzip returns the uncompressed content of a gzip file
+func gunzip(t *testing.T, name string) string {
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGzipFileLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for run := 0; run < 2; run++ {
+		logger, err := log.NewGzipFileLogger(dir, "app", log.LOG_LEVEL_INFO)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 50; i++ {
+			logger.Infof("Run %d message #%02d", run, i)
+		}
+		logger.Close()
+	}
+
+	// the second run appended another gzip member, the tail of both is there
+	data := gunzip(t, filepath.Join(dir, "app.log.gz"))
+	if n := strings.Count(data, "\n"); n != 100 {
+		t.Errorf("file has %d lines, want 100", n)
+	}
+	if !strings.Contains(data, ": Run 0 message #49\n") || !strings.HasSuffix(data, ": Run 1 message #49\n") {
+		t.Errorf("the last messages are missing: %q", data)
+	}
+}
+
+func TestRotatingFileWriterCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "app.log")
+	w, err := log.NewRotatingFileWriter(name, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCompress(true)
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(w, "line %02d of the rotated log\n", i)
+	}
+	w.Close()
+
+	// 3 lines per file: the current file holds 9-11, the backups 6-8 and 3-5
+	if data := gunzip(t, name+".1.gz"); !strings.HasPrefix(data, "line 06") || strings.Count(data, "\n") != 3 {
+		t.Errorf("first backup has %q", data)
+	}
+	if data := gunzip(t, name+".2.gz"); !strings.HasPrefix(data, "line 03") {
+		t.Errorf("second backup has %q", data)
+	}
+	for _, n := range []string{name + ".1", name + ".2", name + ".3.gz"} {
+		if _, err := os.Stat(n); !os.IsNotExist(err) {
+			t.Errorf("%s exists: %v", filepath.Base(n), err)
+		}
+	}
+}
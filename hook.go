@@ -0,0 +1,130 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// Hook runs side effects for logged messages, like counting errors or sending them to an alerting
+// service. Fire is called for every written message at one of the levels returned by Levels, after the
+// message is formatted. An error returned by Fire doesn't stop the message from being written; it is
+// passed to the error handler of the logger, see SetErrorHandler.
+type Hook interface {
+	Levels() []int
+	Fire(t time.Time, level int, message string) error
+}
+
+type hookEntry struct {
+	name    string
+	hook    Hook
+	enabled bool
+}
+
+// hookSet holds the hooks of a logger and its WithFields children
+type hookSet struct {
+	mutex   sync.RWMutex
+	entries []*hookEntry
+}
+
+// AddHook registers a hook with the logger and its WithFields children. Loggers created with Dup
+// afterwards get their own copy of the hooks.
+func (logger *Logger) AddHook(hook Hook) {
+	logger.AddNamedHook("", hook)
+}
+
+// AddNamedHook registers a hook under a name, which can be used to mute it with SetHookEnabled
+func (logger *Logger) AddNamedHook(name string, hook Hook) {
+	s := logger.hooks
+	s.mutex.Lock()
+	s.entries = append(s.entries, &hookEntry{name: name, hook: hook, enabled: true})
+	s.mutex.Unlock()
+}
+
+// SetHookEnabled mutes or unmutes the hooks registered under name without removing them. It returns
+// false if there is no such hook.
+func (logger *Logger) SetHookEnabled(name string, enabled bool) bool {
+	s := logger.hooks
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	found := false
+	for _, e := range s.entries {
+		if e.name == name {
+			e.enabled = enabled
+			found = true
+		}
+	}
+	return found
+}
+
+// fire calls the enabled hooks for the level, passing their errors to onError
+func (s *hookSet) fire(t time.Time, level int, message string, onError ErrorHandler) {
+	if s == nil {
+		return
+	}
+	s.mutex.RLock()
+	var hooks []Hook
+	for _, e := range s.entries {
+		if e.enabled && hasLevel(e.hook.Levels(), level) {
+			hooks = append(hooks, e.hook)
+		}
+	}
+	s.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook.Fire(t, level, message); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// copy returns an independent copy of the hooks
+func (s *hookSet) copy() *hookSet {
+	if s == nil {
+		return &hookSet{}
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c := &hookSet{}
+	for _, e := range s.entries {
+		entry := *e
+		c.entries = append(c.entries, &entry)
+	}
+	return c
+}
+
+// len returns the number of hooks
+func (s *hookSet) len() int {
+	if s == nil {
+		return 0
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.entries)
+}
+
+// names returns the names of the hooks, or their type names if they were added without a name
+func (s *hookSet) names() []string {
+	if s == nil {
+		return nil
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var names []string
+	for _, e := range s.entries {
+		if e.name != "" {
+			names = append(names, e.name)
+		} else {
+			names = append(names, typeName(e.hook))
+		}
+	}
+	return names
+}
+
+func hasLevel(levels []int, level int) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
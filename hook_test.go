@@ -0,0 +1,77 @@
+package log_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	log "."
+)
+
+// countingHook counts the messages it fires for
+type countingHook struct {
+	fired map[int]int
+	err   error
+}
+
+func (h *countingHook) Levels() []int {
+	return []int{log.LOG_LEVEL_ERROR, log.LOG_LEVEL_FATAL}
+}
+
+func (h *countingHook) Fire(t time.Time, level int, message string) error {
+	h.fired[level]++
+	return h.err
+}
+
+func TestHooks(t *testing.T) {
+	logger := log.New(ioutil.Discard, log.LOG_LEVEL_INFO)
+	hook := &countingHook{fired: map[int]int{}}
+	logger.AddNamedHook("alerts", hook)
+
+	logger.Debug("filtered")
+	logger.Info("info")
+	logger.Error("error")
+	logger.WithFields(log.Fields{"k": "v"}).Errorf("error %d", 2)
+	logger.Log(log.LOG_LEVEL_FATAL, "fatal")
+	if hook.fired[log.LOG_LEVEL_ERROR] != 2 || hook.fired[log.LOG_LEVEL_FATAL] != 1 || len(hook.fired) != 2 {
+		t.Errorf("hook fired %v, want 2 ERROR and 1 FATAL", hook.fired)
+	}
+
+	// errors of a hook go to the error handler, the message is written anyway
+	rec := &lineRecorder{}
+	logger = log.New(rec, log.LOG_LEVEL_INFO)
+	var errs []error
+	logger.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	hook = &countingHook{fired: map[int]int{}, err: errors.New("alerting is down")}
+	logger.AddNamedHook("alerts", hook)
+	logger.Error("error")
+	if len(errs) != 1 || len(rec.Lines()) != 1 {
+		t.Errorf("got errors %v and %d lines, want the hook error and 1 line", errs, len(rec.Lines()))
+	}
+	logger.AddHook(&countingHook{fired: map[int]int{}})
+	if names := logger.Config().Hooks; len(names) != 2 || names[0] != "alerts" || names[1] != "*log_test.countingHook" {
+		t.Errorf("Config().Hooks = %q, want alerts and the type of the unnamed hook", names)
+	}
+}
+
+func TestSetHookEnabled(t *testing.T) {
+	logger := log.New(ioutil.Discard, log.LOG_LEVEL_INFO)
+	hook := &countingHook{fired: map[int]int{}}
+	logger.AddNamedHook("alerts", hook)
+	dup := logger.Dup()
+
+	if !logger.SetHookEnabled("alerts", false) {
+		t.Fatal("SetHookEnabled didn't find the hook")
+	}
+	logger.Error("muted")
+	dup.Error("dup keeps its own copy")
+	if logger.SetHookEnabled("unknown", false) {
+		t.Error("SetHookEnabled found an unknown hook")
+	}
+	logger.SetHookEnabled("alerts", true)
+	logger.Error("unmuted")
+	if n := hook.fired[log.LOG_LEVEL_ERROR]; n != 2 {
+		t.Errorf("hook fired %d times, want 2", n)
+	}
+}
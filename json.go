@@ -0,0 +1,182 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSONFormatter formats log messages as single line JSON objects, one per line (JSON Lines):
+// {"time":"2006-01-02T15:04:05Z","level":"INFO","message":"log message...","fields":{"key":"value"}}
+// The fields object is left out when a message has no fields. The caller reported by SetReportCaller is
+// written as "caller":"file.go:42" after the message. In Dev mode the objects are indented and colored
+// instead.
+type JSONFormatter struct {
+	// TimeKey is the name of the timestamp field, "time" if empty
+	TimeKey string
+	// TimeLayout is the layout of the timestamp, time.RFC3339 if empty
+	TimeLayout string
+	// OmitEmpty leaves out fields with zero or empty values like 0, "", nil or an empty slice, and the
+	// fields object if no field is left, for the most compact lines
+	OmitEmpty bool
+	// Dev pretty-prints every message as indented JSON with colored keys and values, for reading logs
+	// in a terminal during development
+	Dev bool
+}
+
+// NewDevJSONFormatter creates a JSONFormatter that pretty-prints messages in Dev mode if w is a
+// terminal, and writes compact JSON lines otherwise, e.g. to files
+func NewDevJSONFormatter(w io.Writer) *JSONFormatter {
+	return &JSONFormatter{Dev: isTerminal(w)}
+}
+
+func (f *JSONFormatter) Format(t time.Time, level int, message string) string {
+	return f.FormatFields(t, level, message, nil)
+}
+
+func (f *JSONFormatter) FormatFields(t time.Time, level int, message string, fields Fields) string {
+	return f.FormatCaller(t, level, message, fields, "")
+}
+
+func (f *JSONFormatter) FormatCaller(t time.Time, level int, message string, fields Fields, caller string) string {
+	timeKey := f.TimeKey
+	if timeKey == "" {
+		timeKey = "time"
+	}
+	layout := f.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeJSONString(&buf, timeKey)
+	buf.WriteByte(':')
+	writeJSONString(&buf, t.Format(layout))
+	buf.WriteString(`,"level":`)
+	writeJSONString(&buf, LogLevel2String(level))
+	buf.WriteString(`,"message":`)
+	writeJSONString(&buf, strings.TrimSuffix(message, "\n"))
+	if caller != "" {
+		buf.WriteString(`,"caller":`)
+		writeJSONString(&buf, caller)
+	}
+	written := 0
+	for _, k := range sortedKeys(fields) {
+		if f.OmitEmpty && isEmptyValue(fields[k]) {
+			continue
+		}
+		if written == 0 {
+			buf.WriteString(`,"fields":{`)
+		} else {
+			buf.WriteByte(',')
+		}
+		writeJSONString(&buf, k)
+		buf.WriteByte(':')
+		writeJSONValue(&buf, fields[k])
+		written++
+	}
+	if written > 0 {
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('}')
+	if f.Dev {
+		return devJSON(buf.Bytes())
+	}
+	buf.WriteByte('\n')
+	return buf.String()
+}
+
+// colors of the keys, strings and other values of Dev mode JSON
+const (
+	jsonKeyColor    = "\x1b[34m"
+	jsonStringColor = "\x1b[32m"
+	jsonValueColor  = "\x1b[33m"
+)
+
+// devJSON indents a compact JSON object and colors its keys and values
+func devJSON(data []byte) string {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return string(data) + "\n"
+	}
+	data = indented.Bytes()
+
+	var buf bytes.Buffer
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+			// a string followed by a colon is a key
+			color := jsonStringColor
+			if rest := bytes.TrimLeft(data[end:], " "); len(rest) > 0 && rest[0] == ':' {
+				color = jsonKeyColor
+			}
+			buf.WriteString(color)
+			buf.Write(data[i:end])
+			buf.WriteString(colorReset)
+			i = end
+		case c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z':
+			// numbers, true, false and null
+			end := i
+			for end < len(data) && strings.IndexByte(",]}\n ", data[end]) < 0 {
+				end++
+			}
+			buf.WriteString(jsonValueColor)
+			buf.Write(data[i:end])
+			buf.WriteString(colorReset)
+			i = end
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	buf.WriteByte('\n')
+	return buf.String()
+}
+
+// isEmptyValue reports whether v is nil, the zero value of its type or an empty string, slice or map
+func isEmptyValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+// writeJSONString writes s as a quoted and escaped JSON string
+func writeJSONString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s) // marshalling a string can't fail
+	buf.Write(data)
+}
+
+// writeJSONValue writes v as JSON. Integers of any size are written as exact JSON numbers, errors are
+// written as their message, and values that can't be represented in JSON are written as strings
+// formatted with %v.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		writeJSONString(buf, err.Error())
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeJSONString(buf, fmt.Sprint(v))
+		return
+	}
+	buf.Write(data)
+}
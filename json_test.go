@@ -0,0 +1,113 @@
+package log_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestJSONFormatter(t *testing.T) {
+	f := &log.JSONFormatter{}
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.UTC)
+	line := f.Format(ts, log.LOG_LEVEL_WARN, "quoted \"value\"\nsecond line")
+
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "}\n") {
+		t.Fatalf("not a single JSON line: %q", line)
+	}
+	var record map[string]string
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("invalid JSON %q: %v", line, err)
+	}
+	if record["time"] != "2014-05-01T12:30:00Z" || record["level"] != "WARN" || record["message"] != "quoted \"value\"\nsecond line" {
+		t.Errorf("unexpected record: %v", record)
+	}
+}
+
+func TestJSONFormatterTimeKeyAndLayout(t *testing.T) {
+	f := &log.JSONFormatter{TimeKey: "@timestamp", TimeLayout: "2006-01-02"}
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	var record map[string]string
+	if err := json.Unmarshal([]byte(f.Format(ts, log.LOG_LEVEL_INFO, "hello\n")), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["@timestamp"] != "2014-05-01" || record["message"] != "hello" {
+		t.Errorf("unexpected record: %v", record)
+	}
+}
+
+func TestJSONFormatterNumbers(t *testing.T) {
+	f := &log.JSONFormatter{}
+	line := f.FormatFields(time.Now(), log.LOG_LEVEL_INFO, "numbers", log.Fields{
+		"max_int64":  int64(math.MaxInt64),
+		"min_int64":  int64(math.MinInt64),
+		"max_uint64": uint64(math.MaxUint64),
+		"pi":         math.Pi,
+	})
+	want := `"fields":{"max_int64":9223372036854775807,"max_uint64":18446744073709551615,"min_int64":-9223372036854775808,"pi":3.141592653589793}`
+	if !strings.Contains(line, want) {
+		t.Errorf("formatted %q, want it to contain %s", line, want)
+	}
+}
+
+func TestJSONFormatterOmitEmpty(t *testing.T) {
+	f := &log.JSONFormatter{OmitEmpty: true}
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.UTC)
+	line := f.FormatFields(ts, log.LOG_LEVEL_INFO, "hello", log.Fields{
+		"user": "", "count": 0, "tags": []string{}, "attrs": map[string]int{}, "err": nil, "ok": false,
+		"status": 200,
+	})
+	if want := `{"time":"2014-05-01T12:30:00Z","level":"INFO","message":"hello","fields":{"status":200}}` + "\n"; line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+
+	// without any field left the fields object is left out too
+	line = f.FormatFields(ts, log.LOG_LEVEL_INFO, "hello", log.Fields{"user": "", "count": 0})
+	if want := `{"time":"2014-05-01T12:30:00Z","level":"INFO","message":"hello"}` + "\n"; line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+
+	// the default keeps empty values
+	f.OmitEmpty = false
+	if line := f.FormatFields(ts, log.LOG_LEVEL_INFO, "hello", log.Fields{"user": ""}); !strings.Contains(line, `"fields":{"user":""}`) {
+		t.Errorf("empty field dropped without OmitEmpty: %q", line)
+	}
+}
+
+func TestJSONFormatterDev(t *testing.T) {
+	f := &log.JSONFormatter{Dev: true}
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.UTC)
+	line := f.FormatFields(ts, log.LOG_LEVEL_INFO, "hello", log.Fields{"status": 200, "path": "/a \"b\""})
+
+	for _, want := range []string{
+		"{\n  \x1b[34m\"time\"\x1b[0m: \x1b[32m\"2014-05-01T12:30:00Z\"\x1b[0m,\n",
+		"\n  \x1b[34m\"fields\"\x1b[0m: {\n    \x1b[34m\"path\"\x1b[0m: \x1b[32m\"/a \\\"b\\\"\"\x1b[0m,\n",
+		"\n    \x1b[34m\"status\"\x1b[0m: \x1b[33m200\x1b[0m\n  }\n}\n",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("dev output %q doesn't contain %q", line, want)
+		}
+	}
+
+	// without colors it is still the same JSON object
+	plain := regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(line, "")
+	var got, want map[string]interface{}
+	if err := json.Unmarshal([]byte(plain), &got); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte((&log.JSONFormatter{}).FormatFields(ts, log.LOG_LEVEL_INFO, "hello", log.Fields{"status": 200, "path": "/a \"b\""})), &want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("dev output decodes to %v, want %v", got, want)
+	}
+
+	// files get compact lines
+	if f := log.NewDevJSONFormatter(&strings.Builder{}); f.Dev {
+		t.Error("dev mode enabled for a writer that isn't a terminal")
+	}
+}
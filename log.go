@@ -1,273 +1,2105 @@
 // Package log provide an easy to use logging package that supports level-based and asynchronized logging.
 // It's designed to be used as a drop-in replacement of the standard log package
+//
+// The log levels are numbered 10 apart, from LOG_LEVEL_TRACE (10) to LOG_LEVEL_FATAL (60), so that custom
+// levels can rank between them, see RegisterLevel. This is a breaking change from the earlier numbering
+// 1 to 6: levels stored, compared or configured as raw numbers must use the new values or the constants.
 package log
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// The log levels are 10 apart, so that levels registered with RegisterLevel can rank between them
 const (
-	_ = iota
-	LOG_LEVEL_TRACE
-	LOG_LEVEL_DEBUG
-	LOG_LEVEL_INFO
-	LOG_LEVEL_WARN
-	LOG_LEVEL_ERROR
-	LOG_LEVEL_FATAL
+	LOG_LEVEL_TRACE = 10
+	LOG_LEVEL_DEBUG = 20
+	LOG_LEVEL_INFO  = 30
+	LOG_LEVEL_WARN  = 40
+	LOG_LEVEL_ERROR = 50
+	LOG_LEVEL_FATAL = 60
 )
 
+// LOG_LEVEL_OFF turns a logger off: it is above every other level, and a logger at this level writes
+// nothing, not even messages logged at LOG_LEVEL_OFF itself
+const LOG_LEVEL_OFF = math.MaxInt32
+
 type HTTPLogWriter struct {
-	url string
+	urls       []string
+	roundRobin bool
+	next       uint32
+	client     *http.Client
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+// DEFAULT_HTTP_TIMEOUT bounds each request of an HTTPLogWriter, so a hung server can't block it forever
+const DEFAULT_HTTP_TIMEOUT = 10 * time.Second
+
+var defaultHTTPClient = &http.Client{Timeout: DEFAULT_HTTP_TIMEOUT}
+
+// NewFailoverHTTPLogWriter creates an HTTPLogWriter posting to the first of the given urls and failing
+// over to the next one when a request fails. With roundRobin set, each message starts at the next url
+// in turn to spread the load.
+func NewFailoverHTTPLogWriter(urls []string, roundRobin bool) *HTTPLogWriter {
+	return &HTTPLogWriter{urls: urls, roundRobin: roundRobin}
+}
+
+// SetRetry makes Write retry up to maxRetries times when all urls fail with a connection error or a 5xx
+// response, waiting baseDelay before the first retry and twice as long before each following one. Write
+// blocks at most for the delays plus one request timeout per attempt and url.
+func (w *HTTPLogWriter) SetRetry(maxRetries int, baseDelay time.Duration) {
+	w.maxRetries = maxRetries
+	w.baseDelay = baseDelay
+}
+
+// SetTimeout sets the timeout of each request, DEFAULT_HTTP_TIMEOUT by default
+func (w *HTTPLogWriter) SetTimeout(timeout time.Duration) {
+	w.client = &http.Client{Timeout: timeout}
+}
+
+// LEVEL_HEADER is the request header carrying the level name of the messages posted by WriteLevel
+const LEVEL_HEADER = "X-Log-Level"
+
+func (w *HTTPLogWriter) Write(data []byte) (n int, err error) {
+	return w.WriteLevel(0, data)
+}
+
+// WriteLevel posts data like Write, with the level name in the LEVEL_HEADER header so the server can
+// route or index messages by level without parsing them
+func (w *HTTPLogWriter) WriteLevel(level int, data []byte) (n int, err error) {
+	if len(w.urls) == 0 {
+		return 0, errors.New("HTTPLogWriter: no url")
+	}
+	start := 0
+	if w.roundRobin {
+		start = int((atomic.AddUint32(&w.next, 1) - 1) % uint32(len(w.urls)))
+	}
+	delay := w.baseDelay
+	for attempt := 0; ; attempt++ {
+		retry := false
+		for i := range w.urls {
+			var r bool
+			r, err = w.post(w.urls[(start+i)%len(w.urls)], level, data)
+			if err == nil {
+				return len(data), nil
+			}
+			retry = retry || r
+		}
+		if !retry || attempt >= w.maxRetries {
+			return 0, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends data to a single url. It reports whether a failed request may succeed when retried.
+func (w *HTTPLogWriter) post(url string, level int, data []byte) (retry bool, err error) {
+	client := w.client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "html/text")
+	if level > 0 {
+		req.Header.Set(LEVEL_HEADER, LogLevel2String(level))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	// any 2xx response means the server took the message
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("HTTPLogWriter: %d error!", resp.StatusCode)
+		return resp.StatusCode >= 500, err
+	}
+	return false, nil
+}
+
+type LogMessage struct {
+	data  []byte
+	level int // 0 if the message was written without a level
+}
+
+// maxPooledMessageSize limits the buffer size of messages kept in the pool, so a few huge messages
+// don't pin a lot of memory
+const maxPooledMessageSize = 64 << 10
+
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return &LogMessage{}
+	},
+}
+
+// newLogMessage returns a pooled message holding a copy of data
+func newLogMessage(level int, data []byte) *LogMessage {
+	msg := messagePool.Get().(*LogMessage)
+	msg.data = append(msg.data[:0], data...)
+	msg.level = level
+	return msg
+}
+
+// release returns the message to the pool. The message must not be used afterwards.
+func (msg *LogMessage) release() {
+	if cap(msg.data) > maxPooledMessageSize {
+		return
+	}
+	msg.data = msg.data[:0]
+	messagePool.Put(msg)
+}
+
+// Flusher is implemented by writers that buffer log data internally. Flush should write out its own
+// buffered data and then flush the writer it wraps, if that writer is a Flusher too, so a single Flush
+// propagates through a whole stack of writers.
+type Flusher interface {
+	Flush() error
+}
+
+// flushWriter flushes w if it implements Flusher
+func flushWriter(w io.Writer) error {
+	if f, ok := w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+const DEFAULT_QUEUE_SIZE = 100
+
+// AsyncLogWriter writes log messages to the wrapped writer from a background goroutine. Write copies the
+// data before queueing it, so callers may reuse or modify their buffer as soon as Write returns.
+// Like the other writers of this package it is a SyncWriteCloser and can be used standalone, e.g. as
+// the output of another logging library.
+//
+// Messages are written in the order they entered the queue. A message whose Write returned before
+// another Write started is always written first, so the messages of each goroutine keep their order.
+// Writes that run concurrently enter the queue in no particular order. Messages handed to the overflow
+// writer bypass the queue and may be written before messages queued earlier.
+type AsyncLogWriter struct {
+	dropped  uint64 // number of messages dropped by the overflow policy, accessed atomically
+	w        io.Writer
+	queue    chan *LogMessage
+	flushes  chan chan flushResult
+	flushed  int // messages written since the last flush, only used by the background goroutine
+	closed   chan int
+	overflow atomic.Value // holds an overflowWriter
+	onError  atomic.Value // holds an ErrorHandler
+	high     atomic.Value // holds a *highWater
+	isHigh   int32        // set while the queue is at or above the high watermark, accessed atomically
+	given    int32        // set when CloseWithTimeout gave up draining the queue, accessed atomically
+
+	// state guards closing the queue against concurrent writes
+	state    sync.RWMutex
+	isClosed bool
+	done     chan int // closed first on Close, so writes blocked on a full queue give up
+	closing  sync.Once
+	policy   OverflowPolicy
+
+	// batching, see NewBatchingAsyncLogWriter
+	batchSize int
+	maxWait   time.Duration
+	batch     []byte
+}
+
+// OverflowPolicy decides what AsyncLogWriter.Write does when the queue is full
+type OverflowPolicy int
+
+const (
+	// BlockOnFull blocks the caller until there is room in the queue
+	BlockOnFull OverflowPolicy = iota
+	// DropOnFull drops the new message
+	DropOnFull
+	// DropOldestOnFull drops the oldest queued message to make room for the new one
+	DropOldestOnFull
+)
+
+// overflowWriter wraps the overflow writer so it can be stored in an atomic.Value
+type overflowWriter struct {
+	w io.Writer
+}
+
+func NewAsyncLogWriter(w io.Writer, n int) *AsyncLogWriter {
+	aw := newAsyncLogWriter(w, n)
+	go aw.run()
+	return aw
+}
+
+// NewBatchingAsyncLogWriter creates an AsyncLogWriter that combines up to batchSize queued messages into
+// a single Write to w, waiting at most maxWait for a batch to fill up. With a maxWait of 0 a batch only
+// takes the messages that are already queued. Batching reduces the syscalls or requests made by w.
+func NewBatchingAsyncLogWriter(w io.Writer, n int, batchSize int, maxWait time.Duration) *AsyncLogWriter {
+	aw := newAsyncLogWriter(w, n)
+	aw.batchSize = batchSize
+	aw.maxWait = maxWait
+	go aw.run()
+	return aw
+}
+
+// NewAsyncLogWriterWithPolicy creates an AsyncLogWriter that handles a full queue according to policy.
+// Dropped messages are counted by DroppedCount.
+func NewAsyncLogWriterWithPolicy(w io.Writer, n int, policy OverflowPolicy) *AsyncLogWriter {
+	aw := newAsyncLogWriter(w, n)
+	aw.policy = policy
+	go aw.run()
+	return aw
+}
+
+func newAsyncLogWriter(w io.Writer, n int) *AsyncLogWriter {
+	if n <= 0 {
+		n = DEFAULT_QUEUE_SIZE
+	}
+	queue := make(chan *LogMessage, n)
+
+	return &AsyncLogWriter{
+		queue:   queue,
+		w:       w,
+		flushes: make(chan chan flushResult),
+		closed:  make(chan int),
+		done:    make(chan int),
+	}
+}
+
+// run processes all queued messages until the queue is closed
+func (w *AsyncLogWriter) run() {
+	defer close(w.closed) // all messages are processed. ready to close
+	for {
+		if atomic.LoadInt32(&w.given) != 0 {
+			// CloseWithTimeout took the remaining messages
+			return
+		}
+		select {
+		case msg, ok := <-w.queue:
+			if !ok || !w.process(msg, true) {
+				return
+			}
+		case done := <-w.flushes:
+			// write out everything queued before the flush request, then flush the inner writer
+			ok := w.drain()
+			done <- flushResult{n: w.flushed, err: flushWriter(w.w)}
+			w.flushed = 0
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// process writes msg, together with the following messages if batching is enabled. It returns false if
+// the queue has been closed.
+func (w *AsyncLogWriter) process(msg *LogMessage, wait bool) bool {
+	if w.batchSize <= 1 {
+		w.write(msg)
+		return true
+	}
+	return w.writeBatch(msg, wait)
+}
+
+func (w *AsyncLogWriter) write(msg *LogMessage) {
+	_, err := writeLevel(w.w, msg.level, msg.data)
+	// the writer is done with the data, so the buffer can be reused
+	msg.release()
+	w.flushed++
+	if err != nil {
+		// the message is discarded, the error handler may report it
+		w.failed(err)
+	}
+}
+
+// failed passes a write error to the error handler, if any
+func (w *AsyncLogWriter) failed(err error) {
+	if handler, _ := w.onError.Load().(ErrorHandler); handler != nil {
+		handler(err)
+	}
+}
+
+// SetErrorHandler sets a function called from the background goroutine with the error of every failed
+// write to the underlying writer. The message that failed is discarded either way.
+func (w *AsyncLogWriter) SetErrorHandler(handler ErrorHandler) {
+	w.onError.Store(handler)
+}
+
+// writeBatch combines msg and up to batchSize-1 following messages into a single write. If wait is set it
+// waits up to maxWait for more messages, otherwise it only takes what is queued already. If the underlying
+// writer is a LevelWriter, consecutive messages of the same level are combined, and each run is written at
+// its own level, so a SplitWriter or Tee still routes every message by its level. It returns false if the
+// queue has been closed.
+func (w *AsyncLogWriter) writeBatch(msg *LogMessage, wait bool) bool {
+	_, levelled := w.w.(LevelWriter)
+	w.batch = append(w.batch[:0], msg.data...)
+	level := msg.level
+	msg.release()
+	w.flushed++
+
+	var timeout <-chan time.Time
+	if wait && w.maxWait > 0 {
+		timer := time.NewTimer(w.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	open := true
+	for n := 1; n < w.batchSize; n++ {
+		var next *LogMessage
+		if timeout != nil {
+			select {
+			case next, open = <-w.queue:
+			case <-timeout:
+			}
+		} else {
+			select {
+			case next, open = <-w.queue:
+			default:
+			}
+		}
+		if next == nil {
+			break
+		}
+		if levelled && next.level != level {
+			w.flushBatch(level)
+			w.batch = w.batch[:0]
+			level = next.level
+		}
+		w.batch = append(w.batch, next.data...)
+		next.release()
+		w.flushed++
+	}
+	w.flushBatch(level)
+	return open
+}
+
+// flushBatch writes the combined messages of the batch at level
+func (w *AsyncLogWriter) flushBatch(level int) {
+	if _, err := writeLevel(w.w, level, w.batch); err != nil {
+		// the batch is discarded, just like a single message
+		w.failed(err)
+	}
+}
+
+// drain writes all currently queued messages. It returns false if the queue has been closed.
+func (w *AsyncLogWriter) drain() bool {
+	for {
+		select {
+		case msg, ok := <-w.queue:
+			if !ok || !w.process(msg, false) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// Close closes the AsyncLogWriter. It will block here until the log message queue is drained, then it
+// closes the underlying writer if it can be closed. Writes waiting for room in a full queue fail with
+// os.ErrClosed. Closing more than once is a no-op.
+func (w *AsyncLogWriter) Close() error {
+	if !w.shut() {
+		<-w.closed
+		return nil
+	}
+	<-w.closed
+	return closeInner(w.w)
+}
+
+// shut stops accepting messages and closes the queue. Writes blocked on a full queue fail with
+// os.ErrClosed rather than keeping the queue from being closed. It returns false if the queue was
+// closed already.
+func (w *AsyncLogWriter) shut() bool {
+	w.closing.Do(func() { close(w.done) })
+	w.state.Lock()
+	defer w.state.Unlock()
+	if w.isClosed {
+		return false
+	}
+	w.isClosed = true
+	close(w.queue)
+	return true
+}
+
+// ErrCloseTimeout is the error of CloseWithTimeout when the queue couldn't be drained in time
+var ErrCloseTimeout = errors.New("log: close timed out")
+
+// CloseWithTimeout is Close giving up after d, e.g. when the underlying writer hangs. On timeout the
+// queued messages are discarded, and their number is returned with ErrCloseTimeout. The message being
+// written at that time is not counted. The background goroutine exits, and the underlying writer is
+// closed, once that write returns.
+func (w *AsyncLogWriter) CloseWithTimeout(d time.Duration) (undrained int, err error) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	if !w.shut() {
+		select {
+		case <-w.closed:
+			return 0, nil
+		case <-timer.C:
+			return 0, ErrCloseTimeout
+		}
+	}
+
+	select {
+	case <-w.closed:
+		return 0, closeInner(w.w)
+	case <-timer.C:
+	}
+
+	// keep the background goroutine from writing anything after its current write
+	atomic.StoreInt32(&w.given, 1)
+	for msg := range w.queue {
+		msg.release()
+		undrained++
+	}
+	go func() {
+		<-w.closed
+		closeInner(w.w)
+	}()
+	return undrained, ErrCloseTimeout
+}
+
+// Sync writes out all queued messages and then syncs the underlying writer if it implements Syncer
+func (w *AsyncLogWriter) Sync() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return syncWriter(w.w)
+}
+
+// Flush blocks until all messages queued before the call are written, then flushes the underlying
+// writer if it implements Flusher. The AsyncLogWriter keeps running after Flush.
+func (w *AsyncLogWriter) Flush() error {
+	_, err := w.FlushN()
+	return err
+}
+
+// flushResult is the outcome of a flush request
+type flushResult struct {
+	n   int
+	err error
+}
+
+// FlushN is Flush, also returning the number of messages written out since the previous flush, which
+// includes the messages this flush waited for
+func (w *AsyncLogWriter) FlushN() (int, error) {
+	done := make(chan flushResult, 1)
+	select {
+	case w.flushes <- done:
+		r := <-done
+		return r.n, r.err
+	case <-w.closed:
+		// the writer is closed, everything has been written already
+		return 0, nil
+	}
+}
+
+// SetOverflowWriter sets a writer receiving the messages that don't fit into the queue. Once set, Write
+// no longer blocks when the queue is full but writes the message to the overflow writer synchronously
+// instead. The overflow writer may be called from several goroutines at once and takes precedence over
+// the overflow policy. Passing nil restores the policy.
+func (w *AsyncLogWriter) SetOverflowWriter(overflow io.Writer) {
+	w.overflow.Store(overflowWriter{w: overflow})
+}
+
+// Write queues a copy of data to be written by the background goroutine, using a pooled buffer.
+func (w *AsyncLogWriter) Write(data []byte) (n int, err error) {
+	return w.WriteLevel(0, data)
+}
+
+// WriteLevel is Write for a message at the given level. The level is passed on to the underlying
+// writer if it is a LevelWriter; a batch only combines messages of the same level then.
+func (w *AsyncLogWriter) WriteLevel(level int, data []byte) (n int, err error) {
+	w.state.RLock()
+	defer w.state.RUnlock()
+	if w.isClosed {
+		return 0, os.ErrClosed
+	}
+	defer w.checkHighWater()
+
+	msg := newLogMessage(level, data)
+	if o, _ := w.overflow.Load().(overflowWriter); o.w != nil {
+		select {
+		case w.queue <- msg:
+			return len(data), nil
+		default:
+			// the queue is full, hand the message over instead of blocking the caller
+			msg.release()
+			return writeLevel(o.w, level, data)
+		}
+	}
+
+	switch w.policy {
+	case DropOnFull:
+		select {
+		case w.queue <- msg:
+		default:
+			msg.release()
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	case DropOldestOnFull:
+		for {
+			select {
+			case w.queue <- msg:
+				return len(data), nil
+			default:
+			}
+			// the background goroutine may take the oldest message first, then there is room anyway
+			select {
+			case old := <-w.queue:
+				old.release()
+				atomic.AddUint64(&w.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case w.queue <- msg:
+		case <-w.done:
+			msg.release()
+			return 0, os.ErrClosed
+		}
+	}
+	return len(data), nil
+}
+
+// DroppedCount returns the number of messages dropped because the queue was full
+func (w *AsyncLogWriter) DroppedCount() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// QueueLen returns the number of messages waiting in the queue, e.g. to watch a slow destination
+func (w *AsyncLogWriter) QueueLen() int {
+	return len(w.queue)
+}
+
+// QueueCap returns the capacity of the queue
+func (w *AsyncLogWriter) QueueCap() int {
+	return cap(w.queue)
 }
 
-func (w *HTTPLogWriter) Write(data []byte) (n int, err error) {
-	resp, err := http.Post(w.url, "html/text", bytes.NewReader(data))
-	if err != nil {
-		return 0, err
+// highWater is the high watermark set by OnHighWater
+type highWater struct {
+	threshold int
+	fn        func(len, cap int)
+}
+
+// OnHighWater sets a function called when a write fills the queue up to threshold messages, so the
+// application can react to a slow destination, e.g. by raising the log level. It is called again only
+// after the queue went below the threshold. fn is called by the writing goroutine and must not close
+// the AsyncLogWriter. A nil fn removes the watermark.
+func (w *AsyncLogWriter) OnHighWater(threshold int, fn func(len, cap int)) {
+	var h *highWater
+	if fn != nil {
+		h = &highWater{threshold: threshold, fn: fn}
+	}
+	w.high.Store(h)
+	atomic.StoreInt32(&w.isHigh, 0)
+}
+
+// checkHighWater calls the high watermark function if the queue just reached the threshold
+func (w *AsyncLogWriter) checkHighWater() {
+	h, _ := w.high.Load().(*highWater)
+	if h == nil {
+		return
+	}
+	n := len(w.queue)
+	if n < h.threshold {
+		atomic.StoreInt32(&w.isHigh, 0)
+	} else if atomic.CompareAndSwapInt32(&w.isHigh, 0, 1) {
+		h.fn(n, cap(w.queue))
+	}
+}
+
+type LogFormatter interface {
+	Format(t time.Time, level int, message string) string
+}
+
+type Logger struct {
+	written     uint64 // number of messages written, accessed atomically
+	mutex       *sync.Mutex
+	guard       *emitGuard // shared with WithFields children, like the mutex
+	level       *LevelVar  // shared with WithFields children if inherit is set
+	inherit     bool       // WithFields children share the level
+	syncLevel   int32      // accessed atomically
+	path        string
+	fname       string
+	writer      io.Writer
+	writeCloser io.WriteCloser
+	closeFn     func() error
+	closeOnce   sync.Once // closes the current writer at most once
+	formatter   LogFormatter
+	dumpStacks  bool
+	stackDepth  int
+	brokenPipe  BrokenPipeHandler
+	lifecycle   bool
+	syncStop    chan int
+
+	fields        Fields
+	dynamicFields func() map[string]interface{}
+	reportCaller  bool
+	onError       ErrorHandler
+	expandDepth   int
+	writeTimeout  time.Duration
+	extractors    []ContextExtractor
+	sampler       *Sampler
+	sampleLevel   int
+	limiter       *rateLimiter
+	prefix        string
+	bufferSize    int
+	taps          *tapSet      // shared with the WithFields children
+	hooks         *hookSet     // shared with the WithFields children
+	verbose       atomic.Value // holds a *verboseField
+	stalled       int32        // set while a timed out write is still pending, accessed atomically
+	switching     int32        // set while the broken pipe handler runs, accessed atomically
+}
+
+// Line endings for DefaultLogFormatter
+const (
+	LF   = "\n"
+	CRLF = "\r\n"
+)
+
+// DefaultLogFormatter format log message in this format: "INFO: 2006-01-02T15:04:05 (UTC): log message..."
+type DefaultLogFormatter struct {
+	// LineEnding terminates every line, LF if empty
+	LineEnding string
+	// Layout is the go time layout of the timestamp, DEFAULT_TIME_LAYOUT if empty
+	Layout string
+	// Local formats timestamps in local time instead of UTC
+	Local bool
+}
+
+// DEFAULT_TIME_LAYOUT is the timestamp layout used by DefaultLogFormatter
+const DEFAULT_TIME_LAYOUT = "2006-01-02T15:04:05 (MST)"
+
+// NewDefaultLogFormatter creates a DefaultLogFormatter with the given timestamp layout, e.g. time.RFC3339,
+// in UTC or in local time
+func NewDefaultLogFormatter(layout string, useUTC bool) *DefaultLogFormatter {
+	return &DefaultLogFormatter{Layout: layout, Local: !useUTC}
+}
+
+func (f *DefaultLogFormatter) Format(t time.Time, level int, message string) string {
+	var buf bytes.Buffer
+	f.formatTo(&buf, t, level, message, nil)
+	return buf.String()
+}
+
+// formatTo writes the formatted message with its fields to buf. The logger calls it directly for a
+// *DefaultLogFormatter; it isn't the exported FormatTo so formatters embedding DefaultLogFormatter to
+// override Format don't become BufferFormatters that bypass their own Format.
+func (f *DefaultLogFormatter) formatTo(buf *bytes.Buffer, t time.Time, level int, message string, fields Fields) {
+	layout := f.Layout
+	if layout == "" {
+		layout = DEFAULT_TIME_LAYOUT
+	}
+	if f.Local {
+		t = t.Local()
+	} else {
+		t = t.UTC()
+	}
+	ending := f.LineEnding
+	if ending == "" {
+		ending = LF
+	}
+	buf.WriteString(LogLevel2String(level))
+	buf.WriteString(": ")
+	buf.Write(t.AppendFormat(buf.AvailableBuffer(), layout))
+	buf.WriteString(": ")
+	writeMessageFields(buf, message, fields)
+	buf.WriteString(ending)
+}
+
+// FormatFields appends the fields to the message as key=value pairs sorted by key
+func (f *DefaultLogFormatter) FormatFields(t time.Time, level int, message string, fields Fields) string {
+	return f.Format(t, level, appendFields(message, fields))
+}
+
+// New creates a new logger with the given writer
+func New(w io.Writer, loglevel int) *Logger {
+	logger := Logger{
+		level:     newLevelVar(loglevel),
+		formatter: &DefaultLogFormatter{},
+		mutex:     &sync.Mutex{},
+		guard:     &emitGuard{},
+		taps:      &tapSet{},
+		hooks:     &hookSet{},
+	}
+	logger.own(w)
+	return &logger
+}
+
+// NewNullLogger creates a logger that discards everything, e.g. for tests or to turn logging off. Its
+// level is LOG_LEVEL_OFF, and a logger writing to io.Discard doesn't format messages unless
+// it has hooks or taps, so the logging calls do next to no work.
+func NewNullLogger() *Logger {
+	return New(io.Discard, LOG_LEVEL_OFF)
+}
+
+// own makes w the writer of the logger, closed by Close if it can be closed. The mutex must be held.
+func (logger *Logger) own(w io.Writer) {
+	logger.writer = w
+	logger.writeCloser = nil
+	logger.closeFn = nil
+	logger.closeOnce = sync.Once{}
+	if wc, ok := w.(io.WriteCloser); ok {
+		logger.writeCloser = wc
+	} else if closeFn := closerOf(w); closeFn != nil {
+		logger.closeFn = closeFn
+	}
+}
+
+// Shutdowner is implemented by writers that are shut down with a context rather than closed, like
+// clients of remote services
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// closerOf returns the function closing w: its Close method, with or without an error result, or its
+// Shutdown method. It returns nil if w can't be closed.
+func closerOf(w io.Writer) func() error {
+	switch c := w.(type) {
+	case io.Closer:
+		return c.Close
+	case interface{ Close() }:
+		return func() error {
+			c.Close()
+			return nil
+		}
+	case Shutdowner:
+		return func() error {
+			return c.Shutdown(context.Background())
+		}
+	}
+	return nil
+}
+
+// closeInner closes a wrapped writer if it can be closed, see closerOf
+func closeInner(w io.Writer) error {
+	if closeFn := closerOf(w); closeFn != nil {
+		return closeFn()
+	}
+	return nil
+}
+
+// WriterFactory opens the writer a logger should write to
+type WriterFactory func() (io.Writer, error)
+
+// NewWithFallback creates a new logger with the writer of the first factory that succeeds. If the
+// first factory fails, the fallback that was used instead is reported to stderr.
+func NewWithFallback(loglevel int, factories ...WriterFactory) (*Logger, error) {
+	var errs []string
+	for i, factory := range factories {
+		w, err := factory()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintf(os.Stderr, "log: falling back to writer #%d (%T): %s\n", i, w, strings.Join(errs, "; "))
+		}
+		return New(w, loglevel), nil
+	}
+	return nil, fmt.Errorf("log: no writer available: %s", strings.Join(errs, "; "))
+}
+
+// NewHTTPLogger creates a logger that sends log to a http server
+func NewHTTPLogger(url string, loglevel int) *Logger {
+	return New(NewAsyncLogWriter(&HTTPLogWriter{urls: []string{url}}, DEFAULT_QUEUE_SIZE), loglevel)
+}
+
+// NewFailoverHTTPLogger creates a logger that sends log to the first available of several http servers
+func NewFailoverHTTPLogger(urls []string, roundRobin bool, loglevel int) *Logger {
+	return New(NewAsyncLogWriter(NewFailoverHTTPLogWriter(urls, roundRobin), DEFAULT_QUEUE_SIZE), loglevel)
+}
+
+// NewBatchingHTTPLogWriter creates a writer that posts log messages to url in batches: each request
+// carries up to batchSize newline-delimited messages, sent once the batch is full or flushInterval
+// after its first message. Close sends the last batch.
+func NewBatchingHTTPLogWriter(url string, batchSize int, flushInterval time.Duration) *AsyncLogWriter {
+	n := DEFAULT_QUEUE_SIZE
+	if n < 2*batchSize {
+		n = 2 * batchSize
+	}
+	return NewBatchingAsyncLogWriter(&HTTPLogWriter{urls: []string{url}}, n, batchSize, flushInterval)
+}
+
+// DEFAULT_LOG_NAME is the log filename used when none is given and the program name is unusable
+const DEFAULT_LOG_NAME = "app"
+
+// defaultLogName returns the program name to be used as log filename
+func defaultLogName() string {
+	if len(os.Args) == 0 {
+		return DEFAULT_LOG_NAME
+	}
+	// os.Args[0] can be empty or just a directory with some launchers
+	switch name := filepath.Base(os.Args[0]); name {
+	case "", ".", "..", string(filepath.Separator):
+		return DEFAULT_LOG_NAME
+	default:
+		return name
+	}
+}
+
+// prepareLogFile creates the log directory if needed and returns the log name and the path of the log file
+func prepareLogFile(logpath string, fname string) (name string, logfile string, err error) {
+	opts := FileLoggerOptions{Dir: logpath, Name: fname}
+	return opts.prepare()
+}
+
+// DEFAULT_LOG_EXT is the extension of log files
+const DEFAULT_LOG_EXT = ".log"
+
+// DEFAULT_FILE_MODE and DEFAULT_DIR_MODE are the permissions of new log files and directories
+const (
+	DEFAULT_FILE_MODE os.FileMode = 0640
+	DEFAULT_DIR_MODE  os.FileMode = 0750
+)
+
+// FileLoggerOptions declares the log file of NewFileLoggerWithOptions. Empty fields take the defaults
+// of NewFileLogger. The modes apply to new files and directories only, minus the umask of the process.
+type FileLoggerOptions struct {
+	// Dir is the directory of the log file, created if missing
+	Dir string
+	// Name is the log file name without extension, the program name if empty
+	Name string
+	// Ext is the extension of the log file like ".jsonl", DEFAULT_LOG_EXT if empty
+	Ext string
+	// FileMode is the permissions of the log file, DEFAULT_FILE_MODE if 0
+	FileMode os.FileMode
+	// DirMode is the permissions of the directory, DEFAULT_DIR_MODE if 0
+	DirMode os.FileMode
+	// Level is the log level of the logger
+	Level int
+}
+
+// prepare creates the log directory if needed and returns the log name and the path of the log file
+func (opts *FileLoggerOptions) prepare() (name string, logfile string, err error) {
+	name = opts.Name
+	if name == "" {
+		// use program name as log filename
+		name = defaultLogName()
+	}
+	ext := opts.Ext
+	if ext == "" {
+		ext = DEFAULT_LOG_EXT
+	} else if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if err := checkLogName(name); err != nil {
+		return "", "", err
+	}
+	if strings.ContainsAny(ext, `/\`) {
+		return "", "", fmt.Errorf("log: invalid log file extension %q", ext)
+	}
+
+	// create the log directory if not exists
+	dirMode := opts.DirMode
+	if dirMode == 0 {
+		dirMode = DEFAULT_DIR_MODE
+	}
+	err = os.MkdirAll(opts.Dir, dirMode)
+	if err != nil {
+		return "", "", err
+	}
+	return name, filepath.Join(opts.Dir, name+ext), nil
+}
+
+// checkLogName rejects log names that aren't plain file names, so that a name like "../etc/passwd"
+// can't put the log file outside of its directory
+func checkLogName(fname string) error {
+	if fname == "." || fname == ".." || strings.ContainsAny(fname, `/\`) || filepath.VolumeName(fname) != "" {
+		return fmt.Errorf("log: invalid log name %q", fname)
+	}
+	return nil
+}
+
+// NewFileLogger creates a new logger which writes logs to the specified logpath and filename
+func NewFileLogger(logpath string, fname string, loglevel int) (logger *Logger, err error) {
+	return NewFileLoggerWithOptions(FileLoggerOptions{Dir: logpath, Name: fname, Level: loglevel})
+}
+
+// NewFileLoggerWithOptions creates a new logger which writes logs to the file declared by opts, e.g.
+// with another extension or permissions than NewFileLogger
+func NewFileLoggerWithOptions(opts FileLoggerOptions) (logger *Logger, err error) {
+	fname, logfile, err := opts.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	// open the log file
+	fileMode := opts.FileMode
+	if fileMode == 0 {
+		fileMode = DEFAULT_FILE_MODE
+	}
+	file, err := os.OpenFile(logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Logger{
+		level:       newLevelVar(opts.Level),
+		path:        opts.Dir,
+		fname:       fname,
+		writeCloser: file,
+		writer:      file,
+		formatter:   &DefaultLogFormatter{},
+		mutex:       &sync.Mutex{},
+		guard:       &emitGuard{},
+		taps:        &tapSet{},
+		hooks:       &hookSet{},
+	}, nil
+}
+
+// SetLogLevel sets the current log level of the logger, and of the loggers sharing its level
+func (logger *Logger) SetLogLevel(level int) {
+	logger.level.Set(level)
+}
+
+// GetLevel returns the current log level of the logger
+func (logger *Logger) GetLevel() int {
+	return logger.level.Level()
+}
+
+// LevelVar holds a log level that can be shared by several loggers, see SetInheritLevel. It is safe for
+// concurrent use.
+type LevelVar struct {
+	level int32 // accessed atomically
+}
+
+func newLevelVar(level int) *LevelVar {
+	return &LevelVar{level: int32(level)}
+}
+
+// Level returns the log level
+func (v *LevelVar) Level() int {
+	return int(atomic.LoadInt32(&v.level))
+}
+
+// Set sets the log level
+func (v *LevelVar) Set(level int) {
+	atomic.StoreInt32(&v.level, int32(level))
+}
+
+// LevelVar returns the variable holding the level of the logger. Setting it is SetLogLevel.
+func (logger *Logger) LevelVar() *LevelVar {
+	return logger.level
+}
+
+// SetInheritLevel makes the loggers created from now on with WithFields, and their own children, share
+// the level of this logger: SetLogLevel on any of them changes the level of all. Otherwise a child
+// starts with the level of its parent and keeps its own.
+func (logger *Logger) SetInheritLevel(inherit bool) {
+	logger.mutex.Lock()
+	logger.inherit = inherit
+	logger.mutex.Unlock()
+}
+
+// childLevel returns the level of a child logger. The mutex must be held.
+func (logger *Logger) childLevel() *LevelVar {
+	if logger.inherit {
+		return logger.level
+	}
+	return newLevelVar(logger.level.Level())
+}
+
+// IsLevelEnabled reports whether messages at the given level would be written, so expensive messages
+// can be skipped: if logger.IsLevelEnabled(LOG_LEVEL_DEBUG) { logger.Debugf(...) }
+func (logger *Logger) IsLevelEnabled(level int) bool {
+	return logger.enabled(level)
+}
+
+// SetFormater sets the current formater to the new one
+func (logger *Logger) SetFormatter(formatter LogFormatter) {
+	logger.mutex.Lock()
+	logger.formatter = formatter
+	logger.mutex.Unlock()
+}
+
+// Close closes logger. If the log writer has a Close method, with or without an error result, or a
+// Shutdown(context.Context) method, the logger will close the writer too.
+func (logger *Logger) Close() {
+	if logger.lifecycleEvents() {
+		logger.logEvent("logger_closed", logger.closeStats())
+	}
+	logger.mutex.Lock()
+	logger.stopSyncing()
+	logger.closeWriter()
+	logger.mutex.Unlock()
+}
+
+// Flush writes out the messages buffered by the writer of the logger, if it implements Flusher, without
+// closing it. For an AsyncLogWriter it blocks until every message logged before the call is written
+// and returns the number of messages written since the previous flush; it returns 0 for other writers.
+func (logger *Logger) Flush() (int, error) {
+	w := logger.Writer()
+	if f, ok := w.(countingFlusher); ok {
+		return f.FlushN()
+	}
+	return 0, flushWriter(w)
+}
+
+// countingFlusher is implemented by writers that count the messages they flush, like AsyncLogWriter
+type countingFlusher interface {
+	FlushN() (int, error)
+}
+
+// Syncer is implemented by writers that can commit written data to stable storage, like *os.File
+type Syncer interface {
+	Sync() error
+}
+
+// SyncWriteCloser is the interface implemented by the writers of this package (AsyncLogWriter,
+// RotatingFileWriter, ...), which makes them usable on their own by any code that wants an io.Writer.
+type SyncWriteCloser interface {
+	io.WriteCloser
+	Syncer
+}
+
+// syncWriter syncs w if it implements Syncer
+func syncWriter(w io.Writer) error {
+	if s, ok := w.(Syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// SetSyncInterval makes the logger sync its writer to stable storage every interval, whether or not
+// anything has been written, so a crash loses at most one interval of logs. The writer must implement
+// Syncer. An interval of 0 stops syncing.
+func (logger *Logger) SetSyncInterval(interval time.Duration) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.stopSyncing()
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan int)
+	logger.syncStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				syncWriter(logger.Writer())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Sync writes out the messages buffered or queued by the writer, then commits them to stable storage if
+// the writer implements Syncer, e.g. before acknowledging a request whose audit log must survive a crash
+func (logger *Logger) Sync() error {
+	return flushAndSync(logger.Writer())
+}
+
+// SetSyncLevel makes the logger sync its writer after every message at or above level, like Sync, so
+// e.g. ERROR and FATAL lines are on disk when the log call returns. A failed sync counts as a failed
+// write. A level of 0 stops syncing.
+func (logger *Logger) SetSyncLevel(level int) {
+	atomic.StoreInt32(&logger.syncLevel, int32(level))
+}
+
+// flushAndSync flushes w if it implements Flusher, then syncs it if it implements Syncer
+func flushAndSync(w io.Writer) error {
+	if err := flushWriter(w); err != nil {
+		return err
+	}
+	return syncWriter(w)
+}
+
+// stopSyncing stops the background syncing started by SetSyncInterval. The mutex must be held.
+func (logger *Logger) stopSyncing() {
+	if logger.syncStop != nil {
+		close(logger.syncStop)
+		logger.syncStop = nil
+	}
+}
+
+// closeWriter runs the close behavior of the current writer, if there is any. Close, Fatal and Panic
+// all go through it, so the writer is closed only once however many of them are called.
+func (logger *Logger) closeWriter() (err error) {
+	logger.closeOnce.Do(func() {
+		if logger.closeFn != nil {
+			err = logger.closeFn()
+		} else if logger.writeCloser != nil {
+			err = logger.writeCloser.Close()
+		}
+	})
+	return err
+}
+
+// SetWriter replaces the writer of the logger, e.g. to reopen a log file after it was rotated. The
+// logger owns w from now on and closes it on Close, like a writer passed to New. The previous writer
+// is closed if the logger owned it; use SwapWriter to keep it open. Loggers created from this one with
+// WithFields before the call keep the previous writer.
+func (logger *Logger) SetWriter(w io.Writer) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.closeWriter()
+	logger.own(w)
+}
+
+// SwapWriter is SetWriter without closing the previous writer, which it returns instead. The caller is
+// responsible for closing it.
+func (logger *Logger) SwapWriter(w io.Writer) io.Writer {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	old := logger.writer
+	logger.own(w)
+	return old
+}
+
+// SetOutput sets the writer of the logger like SetOutput of the standard log package: the previous
+// writer is not closed. The logger owns w and closes it on Close, see SwapWriter.
+func (logger *Logger) SetOutput(w io.Writer) {
+	logger.SwapWriter(w)
+}
+
+// SetPrefix sets a prefix written at the start of every line, before the output of the formatter.
+// Loggers created with WithFields or Dup afterwards inherit it.
+func (logger *Logger) SetPrefix(prefix string) {
+	logger.mutex.Lock()
+	logger.prefix = prefix
+	logger.mutex.Unlock()
+}
+
+// SetBufferSize sets the initial capacity of the buffer a message is formatted into, so that messages up
+// to size bytes don't grow it. Pooled buffers keep their capacity, so this matters most for the first
+// messages and for messages above 64KB, whose buffers aren't pooled. Loggers created with WithFields or
+// Dup afterwards inherit it.
+func (logger *Logger) SetBufferSize(size int) {
+	logger.mutex.Lock()
+	logger.bufferSize = size
+	logger.mutex.Unlock()
+}
+
+// SetWriterWithClose sets the writer of the logger together with the cleanup function Close should run
+// for it, e.g. flushing a client before closing its connection. The previous writer is not closed.
+func (logger *Logger) SetWriterWithClose(w io.Writer, closeFn func() error) {
+	logger.mutex.Lock()
+	logger.writer = w
+	logger.writeCloser = nil
+	logger.closeFn = closeFn
+	logger.closeOnce = sync.Once{}
+	logger.mutex.Unlock()
+}
+
+// Dup returns an independent copy of the logger that shares only its writer. The copy starts with the
+// logger's current configuration and has its own mutex, so changing its level, formatter or fields
+// doesn't affect the original and the other way round. The copy doesn't own the writer: closing it
+// doesn't close the writer.
+//
+// Neither mutex is held while writing, so the original and the copy may write at the same time: the
+// shared writer must be safe for concurrent use, like *os.File or the writers of this package. Wrap
+// others, e.g. a bytes.Buffer, in an AsyncLogWriter, which writes from a single goroutine.
+func (logger *Logger) Dup() *Logger {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	dup := &Logger{
+		mutex:         &sync.Mutex{},
+		guard:         &emitGuard{},
+		level:         newLevelVar(logger.level.Level()),
+		inherit:       logger.inherit,
+		syncLevel:     atomic.LoadInt32(&logger.syncLevel),
+		path:          logger.path,
+		fname:         logger.fname,
+		writer:        logger.writer,
+		formatter:     logger.formatter,
+		dumpStacks:    logger.dumpStacks,
+		stackDepth:    logger.stackDepth,
+		brokenPipe:    logger.brokenPipe,
+		dynamicFields: logger.dynamicFields,
+		reportCaller:  logger.reportCaller,
+		onError:       logger.onError,
+		expandDepth:   logger.expandDepth,
+		writeTimeout:  logger.writeTimeout,
+		extractors:    logger.extractors,
+		sampler:       logger.sampler,
+		sampleLevel:   logger.sampleLevel,
+		limiter:       logger.limiter,
+		prefix:        logger.prefix,
+		bufferSize:    logger.bufferSize,
+		taps:          &tapSet{},
+		hooks:         logger.hooks.copy(),
+	}
+	dup.verbose.Store(logger.verboseField())
+	if len(logger.fields) > 0 {
+		dup.fields = mergeFields(logger.fields, nil)
+	}
+	return dup
+}
+
+// Clone is Dup: it returns a copy of the logger with its own mutex and configuration that shares only
+// the writer, which the clone doesn't own, so closing the clone leaves the writer open. Use WithFields
+// instead for a child that shares the mutex, hooks and taps of its parent.
+func (logger *Logger) Clone() *Logger {
+	return logger.Dup()
+}
+
+// Writer returns current writer of the logger.
+func (logger *Logger) Writer() io.Writer {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	return logger.writer
+}
+
+// LevelWriter returns an io.Writer logging everything written to it as one message at the given level,
+// without a trailing newline. It lets code that only takes an io.Writer or a standard library
+// *log.Logger log through the logger, e.g. stdlog.New(logger.LevelWriter(LOG_LEVEL_WARN), "", 0).
+func (logger *Logger) LevelWriter(level int) io.Writer {
+	return &logWriter{logger: logger, level: level}
+}
+
+// logWriter is the io.Writer returned by Logger.LevelWriter
+type logWriter struct {
+	logger *Logger
+	level  int
+}
+
+func (w *logWriter) Write(p []byte) (n int, err error) {
+	if w.logger.enabled(w.level) {
+		w.logger.emit(time.Now(), w.level, strings.TrimSuffix(string(p), "\n"))
+	}
+	return len(p), nil
+}
+
+// HasWriter reports whether w is the writer of the logger, or one of the writers of its MultiWriter
+func (logger *Logger) HasWriter(w io.Writer) bool {
+	current := logger.Writer()
+	if m, ok := current.(*MultiWriter); ok && m.has(w) {
+		return true
+	}
+	return sameWriter(current, w)
+}
+
+// sameWriter reports whether a and b are the same writer, without panicking on writers of
+// uncomparable types. Slices and maps are the same if they share their data.
+func sameWriter(a, b io.Writer) bool {
+	if a == nil || b == nil || reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if reflect.TypeOf(a).Comparable() {
+		return a == b
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch va.Kind() {
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	case reflect.Map:
+		return va.Pointer() == vb.Pointer()
+	}
+	return false
+}
+
+// BrokenPipeHandler is called when a write fails because the reading end of the pipe is gone (EPIPE).
+// It returns the writer the logger should use from then on.
+type BrokenPipeHandler func(w io.Writer, err error) io.Writer
+
+// DefaultBrokenPipeHandler switches the logger to stderr, or discards the logs if stderr itself is broken.
+func DefaultBrokenPipeHandler(w io.Writer, err error) io.Writer {
+	if unwrapStream(w) == os.Stderr {
+		return io.Discard
+	}
+	return os.Stderr
+}
+
+// SetBrokenPipeHandler sets the handler invoked when writing fails with EPIPE. The default handler is
+// DefaultBrokenPipeHandler. The handler may log and call the setters of the logger; messages failing
+// with EPIPE while it runs, including its own, fail without calling it again. If it sets a writer with
+// SetWriter, that writer is kept rather than the one it returns.
+func (logger *Logger) SetBrokenPipeHandler(handler BrokenPipeHandler) {
+	logger.mutex.Lock()
+	logger.brokenPipe = handler
+	logger.mutex.Unlock()
+}
+
+// write writes a formatted message, or its record to a RecordWriter, to the writer of the logger, and
+// passes the error to handler if that fails
+func (logger *Logger) write(level int, msg []byte, rec LogRecord, handler ErrorHandler) {
+	err := logger.output(level, msg, rec)
+	if err == nil {
+		atomic.AddUint64(&logger.written, 1)
+		return
+	}
+	if handler != nil {
+		logger.guard.enterCallback()
+		handler(err)
+		logger.guard.leaveCallback()
+	}
+}
+
+// ErrorHandler is called with the error of a failed write to a log writer
+type ErrorHandler func(err error)
+
+// errorReporter is implemented by writers that report their write errors themselves, like the
+// AsyncLogWriter whose writes happen in the background
+type errorReporter interface {
+	SetErrorHandler(handler ErrorHandler)
+}
+
+// SetErrorHandler sets a function called whenever writing a message fails, which is otherwise silent. If
+// the writer reports errors of its own, like AsyncLogWriter, the handler is passed on to it. The
+// handler must not log to the same logger.
+func (logger *Logger) SetErrorHandler(handler ErrorHandler) {
+	logger.mutex.Lock()
+	logger.onError = handler
+	w := logger.writer
+	logger.mutex.Unlock()
+	if r, ok := w.(errorReporter); ok {
+		r.SetErrorHandler(handler)
+	}
+}
+
+// output writes msg, or rec to a RecordWriter, to the writer, switching writers if the current one is a
+// broken pipe
+func (logger *Logger) output(level int, msg []byte, rec LogRecord) error {
+	logger.mutex.Lock()
+	w := logger.writer
+	timeout := logger.writeTimeout
+	logger.mutex.Unlock()
+	if w == nil {
+		return nil
+	}
+	var err error
+	if timeout > 0 {
+		err = logger.writeWithTimeout(w, level, msg, rec, timeout)
+	} else {
+		err = writeMessage(w, level, msg, rec)
+	}
+	if err != nil && errors.Is(err, syscall.EPIPE) && atomic.CompareAndSwapInt32(&logger.switching, 0, 1) {
+		// the consumer of the pipe went away, let the handler pick a new writer rather than
+		// failing on every message from now on. The handler runs without the mutex, and its writer
+		// replaces the broken one only if no one else did in the meantime.
+		logger.mutex.Lock()
+		handler := logger.brokenPipe
+		logger.mutex.Unlock()
+		if handler == nil {
+			handler = DefaultBrokenPipeHandler
+		}
+		replacement := handler(w, err)
+		logger.mutex.Lock()
+		if sameWriter(logger.writer, w) {
+			logger.writer = replacement
+		}
+		w = logger.writer
+		logger.mutex.Unlock()
+		atomic.StoreInt32(&logger.switching, 0)
+		if w != nil {
+			err = writeMessage(w, level, msg, rec)
+		}
+	}
+	if syncLevel := atomic.LoadInt32(&logger.syncLevel); err == nil && w != nil && syncLevel > 0 && level >= int(syncLevel) {
+		err = flushAndSync(w)
+	}
+	return err
+}
+
+// ErrWriteTimeout is the error of writes that didn't finish within the timeout set by SetWriteTimeout
+var ErrWriteTimeout = errors.New("log: write timed out")
+
+// SetWriteTimeout bounds the time a log call waits for the writer. The write runs in its own goroutine,
+// and if it doesn't finish in time the call returns and the message counts as failed with
+// ErrWriteTimeout. The goroutine stays blocked until the writer returns; to avoid piling up goroutines
+// and concurrent writes, messages are failed right away while such a write is pending. A timeout of 0,
+// the default, writes synchronously without a goroutine.
+//
+// A write that timed out still goes on after the log call returned: the writer must tolerate it
+// finishing at any later time, e.g. after SetWriter replaced the writer or while it is being closed. The
+// write has its own copy of the message and fields, so the caller may reuse them right away.
+func (logger *Logger) SetWriteTimeout(timeout time.Duration) {
+	logger.mutex.Lock()
+	logger.writeTimeout = timeout
+	logger.mutex.Unlock()
+}
+
+// writeWithTimeout writes data to w, giving up after timeout. The goroutine writing keeps w and rec
+// after a timeout; rec.Fields is never the caller's map, emitFields merges the fields into a new one.
+func (logger *Logger) writeWithTimeout(w io.Writer, level int, data []byte, rec LogRecord, timeout time.Duration) error {
+	if atomic.LoadInt32(&logger.stalled) != 0 {
+		return ErrWriteTimeout
+	}
+	// the write may outlive the call, which reuses data afterwards
+	data = append([]byte(nil), data...)
+	done := make(chan error, 1)
+	go func() {
+		done <- writeMessage(w, level, data, rec)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		atomic.StoreInt32(&logger.stalled, 1)
+		go func() {
+			<-done
+			atomic.StoreInt32(&logger.stalled, 0)
+		}()
+		return ErrWriteTimeout
+	}
+}
+
+// ConfigSnapshot describes the effective configuration of a logger
+type ConfigSnapshot struct {
+	Level     int
+	LevelName string
+	Formatter string   // type name of the formatter
+	Writer    string   // type name of the writer
+	Hooks     []string // names of the hooks in the order they were added, the type name for unnamed ones
+}
+
+// typeName returns the name of the dynamic type of v
+func typeName(v interface{}) string {
+	if v == nil {
+		return "<nil>"
+	}
+	return reflect.TypeOf(v).String()
+}
+
+// Config returns a snapshot of the logger's current configuration, useful for diagnosing misconfiguration.
+func (logger *Logger) Config() ConfigSnapshot {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	return ConfigSnapshot{
+		Level:     logger.GetLevel(),
+		LevelName: LogLevel2String(logger.GetLevel()),
+		Formatter: typeName(logger.formatter),
+		Writer:    typeName(logger.writer),
+		Hooks:     logger.hooks.names(),
+	}
+}
+
+func (logger *Logger) Format(t time.Time, level int, message string) string {
+	var msg string
+	logger.mutex.Lock()
+	if logger.formatter != nil {
+		logger.guard.startFormatting()
+		msg = logger.formatter.Format(t, level, message)
+		logger.guard.stopFormatting()
+	}
+	logger.mutex.Unlock()
+	return msg
+}
+
+// emit formats a message together with the fields of the logger and writes it to the writer
+func (logger *Logger) emit(t time.Time, level int, message string) {
+	logger.emitFields(t, level, message, nil, "")
+}
+
+// emitFields is emit with additional fields for this message only. The caller is looked up if it's
+// not given and the logger reports callers.
+func (logger *Logger) emitFields(t time.Time, level int, message string, extra Fields, caller string) {
+	if !logger.lock(1) {
+		return
+	}
+	fields := logger.fields
+	provider := logger.dynamicFields
+	reportCaller := logger.reportCaller
+	expandDepth := logger.expandDepth
+	sampler := logger.sampler
+	sampled := sampler != nil && level <= logger.sampleLevel
+	limiter := logger.limiter
+	prefix := logger.prefix
+	bufferSize := logger.bufferSize
+	onError := logger.onError
+	logger.mutex.Unlock()
+
+	// a message logged by a field provider, hook or error handler of this logger on the same goroutine
+	// is written without running them again, so they can't recurse
+	nested := logger.guard.nested(1)
+	if nested {
+		provider, onError = nil, nil
+	}
+
+	if sampled && !sampler.allow(level, message) {
+		return
+	}
+	if limiter != nil && !limiter.allow() {
+		return
+	}
+
+	if caller == "" && reportCaller {
+		caller = callerOutside()
 	}
-	defer resp.Body.Close()
 
-	// check response code
-	if resp.StatusCode != http.StatusOK {
-		err = errors.New(fmt.Sprintf("HTTPLogWriter: %s error!", resp.StatusCode))
-		return 0, err
+	if provider != nil || len(extra) > 0 {
+		fields = mergeFields(fields, extra)
+		if provider != nil {
+			logger.guard.enterCallback()
+			for k, v := range provider() {
+				fields[k] = v
+			}
+			logger.guard.leaveCallback()
+		}
+	}
+	if expandDepth > 0 && len(fields) > 0 {
+		fields = expandStructs(fields, expandDepth)
+	}
+	if level >= LOG_LEVEL_ERROR && len(fields) > 0 {
+		fields = withErrorStack(fields)
+	}
+	buf := getBuffer()
+	if bufferSize > 0 {
+		buf.Grow(bufferSize)
+	}
+	defer putBuffer(buf)
+	buf.WriteString(prefix)
+	logger.formatTo(buf, t, level, message, fields, caller)
+	if buf.Len() == len(prefix) {
+		// the formatter wrote nothing
+		buf.Reset()
+	}
+	if !logger.taps.active() || logger.writableAt(level, extra, 1) {
+		logger.write(level, buf.Bytes(), LogRecord{Time: t, Level: level, Message: message, Fields: fields, Caller: caller}, onError)
+		if !nested && logger.hooks.len() > 0 {
+			logger.guard.enterCallback()
+			logger.hooks.fire(t, level, message, onError)
+			logger.guard.leaveCallback()
+		}
+	}
+	if logger.taps.wants(level) {
+		logger.taps.send(level, buf.String())
 	}
-	return len(data), err
 }
 
-type LogMessage struct {
-	data []byte
+// BufferFormatter is implemented by formatters that write into a buffer rather than returning a string,
+// which saves allocating the string. The logger calls FormatTo instead of Format and FormatFields when
+// the message has no caller to report. The buffer must not be retained.
+type BufferFormatter interface {
+	LogFormatter
+	FormatTo(buf *bytes.Buffer, t time.Time, level int, message string, fields Fields)
 }
 
-const DEFAULT_QUEUE_SIZE = 100
+// maxPooledBufferSize limits the size of the formatting buffers kept in the pool, so a few huge messages
+// don't pin a lot of memory
+const maxPooledBufferSize = 64 << 10
 
-type AsyncLogWriter struct {
-	w      io.Writer
-	queue  chan LogMessage
-	closed chan int
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
 }
 
-func NewAsyncLogWriter(w io.Writer, n int) *AsyncLogWriter {
-	if n <= 0 {
-		n = DEFAULT_QUEUE_SIZE
+// WarmBufferPool fills the pool of formatting buffers, which all loggers share, with n buffers of size
+// bytes, so that the first log calls after startup don't allocate them. Sizes above 64KB are capped
+// since larger buffers aren't pooled. Like any sync.Pool, the pool is emptied over time by the garbage
+// collector when the buffers aren't used.
+func WarmBufferPool(n int, size int) {
+	if size > maxPooledBufferSize {
+		size = maxPooledBufferSize
+	}
+	for i := 0; i < n; i++ {
+		buf := new(bytes.Buffer)
+		buf.Grow(size)
+		bufferPool.Put(buf)
 	}
-	queue := make(chan LogMessage, n)
+}
+
+// getBuffer returns an empty pooled formatting buffer
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
 
-	aw := &AsyncLogWriter{
-		queue:  queue,
-		w:      w,
-		closed: make(chan int),
+// putBuffer returns buf to the pool. It must not be used afterwards.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
 	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
 
-	go func(w *AsyncLogWriter) {
-		// process all queued messages until the queue is closed
-		for msg := range w.queue {
-			_, err := w.w.Write(msg.data)
-			if err != nil {
-				// the writer failed to write the message somehow,
-				// we just discard the message here, but other implementations
-				// might try to resend the message
-			}
+// formatTo formats a message with fields and caller into buf, directly for formatters that can write
+// into a buffer and through the string returned by the formatter for the others
+func (logger *Logger) formatTo(buf *bytes.Buffer, t time.Time, level int, message string, fields Fields, caller string) {
+	if caller == "" {
+		logger.mutex.Lock()
+		switch f := logger.formatter.(type) {
+		case *DefaultLogFormatter:
+			f.formatTo(buf, t, level, message, fields)
+			logger.mutex.Unlock()
+			return
+		case BufferFormatter:
+			logger.guard.startFormatting()
+			f.FormatTo(buf, t, level, message, fields)
+			logger.guard.stopFormatting()
+			logger.mutex.Unlock()
+			return
 		}
-		w.closed <- 1 // all messages are processed. ready to close
-	}(aw)
-
-	return aw
+		logger.mutex.Unlock()
+	}
+	buf.WriteString(logger.formatFields(t, level, message, fields, caller))
 }
 
-// Close closes the AsyncLogWriter. It will block here until the log message queue is drained.
-func (w *AsyncLogWriter) Close() {
-	close(w.queue)
-	<-w.closed
+// emitGuard keeps messages logged from the callbacks of a logger to the same logger, e.g. by a formatter,
+// field provider, hook or error handler, from deadlocking or recursing forever. It is shared by a logger
+// and its WithFields children, like their mutex. Its flags only tell that a callback is running on some
+// goroutine; whether it runs on the calling one is told by the stack of the caller, see emitDepth.
+type emitGuard struct {
+	formatting int32 // set while the holder of the mutex runs the formatter, accessed atomically
+	callbacks  int32 // number of field provider, hook and error handler calls running, accessed atomically
 }
 
-func (w *AsyncLogWriter) Write(data []byte) (n int, err error) {
-	w.queue <- LogMessage{data: data}
-	return len(data), nil
+// reentrantWait is how long a goroutine logging from within a formatter waits for a mutex held while
+// formatting before it assumes that it is the holder, logging from its own formatter
+const reentrantWait = 10 * time.Millisecond
+
+func (g *emitGuard) startFormatting() {
+	atomic.StoreInt32(&g.formatting, 1)
 }
 
-type LogFormatter interface {
-	Format(t time.Time, level int, message string) string
+func (g *emitGuard) stopFormatting() {
+	atomic.StoreInt32(&g.formatting, 0)
 }
 
-type Logger struct {
-	mutex       *sync.Mutex
-	level       int
-	path        string
-	fname       string
-	writer      io.Writer
-	writeCloser io.WriteCloser
-	formatter   LogFormatter
+func (g *emitGuard) enterCallback() {
+	atomic.AddInt32(&g.callbacks, 1)
 }
 
-// DefaultLogFormatter format log message in this format: "INFO: 2006-01-02T15:04:05 (UTC): log message..."
-type DefaultLogFormatter struct {
+func (g *emitGuard) leaveCallback() {
+	atomic.AddInt32(&g.callbacks, -1)
 }
 
-func (f *DefaultLogFormatter) Format(t time.Time, level int, message string) string {
-	timeStr := t.UTC().Format("2006-01-02T15:04:05 (MST)")
-	return fmt.Sprintf("%s: %s: %s\n", LogLevel2String(level), timeStr, message)
+// nested reports whether the calling goroutine logs from within a field provider, hook or error handler,
+// when it is already logging depth messages itself. The stack is only walked while a callback runs.
+func (g *emitGuard) nested(depth int) bool {
+	return atomic.LoadInt32(&g.callbacks) > 0 && emitDepth() > depth
 }
 
-// New creates a new logger with the given writer
-func New(w io.Writer, loglevel int) *Logger {
-	logger := Logger{
-		level:     loglevel,
-		writer:    w,
-		formatter: &DefaultLogFormatter{},
-		mutex:     &sync.Mutex{},
-	}
-	if wc, ok := w.(io.WriteCloser); ok {
-		logger.writeCloser = wc
+// emitFuncs are the functions running while a goroutine logs a message
+var emitFuncs = [...]string{packagePath + ".(*Logger).emitFields", packagePath + ".(*Logger).Format"}
+
+// emitDepth returns the number of messages the calling goroutine is logging, i.e. the frames of
+// emitFuncs on its stack
+func emitDepth() int {
+	var pcs [64]uintptr
+	depth := 0
+	for skip := 2; ; skip += len(pcs) {
+		n := runtime.Callers(skip, pcs[:])
+		frames := runtime.CallersFrames(pcs[:n])
+		for {
+			frame, more := frames.Next()
+			if frame.Function == emitFuncs[0] || frame.Function == emitFuncs[1] {
+				depth++
+			}
+			if !more {
+				break
+			}
+		}
+		if n < len(pcs) {
+			return depth
+		}
 	}
-	return &logger
 }
 
-// NewHTTPLogger creates a logger that sends log to a http server
-func NewHTTPLogger(url string, loglevel int) *Logger {
-	return &Logger{
-		level:     loglevel,
-		writer:    NewAsyncLogWriter(&HTTPLogWriter{url: url}, DEFAULT_QUEUE_SIZE),
-		formatter: &DefaultLogFormatter{},
-		mutex:     &sync.Mutex{},
+// lock takes the mutex of the logger for a goroutine that is already logging depth messages itself.
+// Goroutines waiting for another one simply wait. A goroutine logging from within a formatter while the
+// mutex is held for formatting might be the holder, so it waits at most reentrantWait. It then drops
+// the message: lock reports false without taking the mutex.
+func (logger *Logger) lock(depth int) bool {
+	if logger.mutex.TryLock() {
+		return true
 	}
+	if atomic.LoadInt32(&logger.guard.formatting) == 0 || emitDepth() <= depth {
+		logger.mutex.Lock()
+		return true
+	}
+	deadline := time.Now().Add(reentrantWait)
+	for atomic.LoadInt32(&logger.guard.formatting) != 0 {
+		if logger.mutex.TryLock() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			warnReentrant()
+			return false
+		}
+		runtime.Gosched()
+	}
+	logger.mutex.Lock()
+	return true
 }
 
-// NewFileLogger creates a new logger which writes logs to the specified logpath and filename
-func NewFileLogger(logpath string, fname string, loglevel int) (logger *Logger, err error) {
+// reentered reports the first dropped reentrant message
+var reentered sync.Once
 
-	// create the log directory if not exists
-	err = os.MkdirAll(logpath, 0750)
-	if err != nil {
-		return nil, err
-	}
+func warnReentrant() {
+	reentered.Do(func() {
+		fmt.Fprintln(os.Stderr, "log: dropping messages logged while logging, e.g. from a formatter")
+	})
+}
 
-	// use program name as log filename
-	if fname == "" {
-		fname = path.Base(os.Args[0])
+// formatFields formats a message with fields and caller, appending them to the message if the formatter
+// can't render them itself
+func (logger *Logger) formatFields(t time.Time, level int, message string, fields Fields, caller string) string {
+	if len(fields) == 0 && caller == "" {
+		return logger.Format(t, level, message)
 	}
-	filepath := fmt.Sprintf("%s/%s.log", logpath, fname)
-
-	// open the log file
-	file, err := os.OpenFile(filepath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
-	if err != nil {
-		return nil, err
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logger.formatter == nil {
+		return ""
 	}
-
-	return &Logger{
-		level:       loglevel,
-		path:        logpath,
-		fname:       fname,
-		writeCloser: file,
-		writer:      file,
-		formatter:   &DefaultLogFormatter{},
-		mutex:       &sync.Mutex{},
-	}, nil
+	logger.guard.startFormatting()
+	defer logger.guard.stopFormatting()
+	return formatWith(logger.formatter, t, level, message, fields, caller)
 }
 
-// SetLogLevel sets the current log level of the logger
-func (logger *Logger) SetLogLevel(level int) {
-	logger.level = level
+// formatWith formats a message with fields and caller using f, appending them to the message if f
+// can't render them itself
+func formatWith(f LogFormatter, t time.Time, level int, message string, fields Fields, caller string) string {
+	if cf, ok := f.(CallerFormatter); ok {
+		return cf.FormatCaller(t, level, message, fields, caller)
+	}
+	if caller != "" {
+		message = appendFields(message, fields)
+		trimmed := strings.TrimSuffix(message, "\n")
+		return f.Format(t, level, trimmed+" ("+caller+")"+message[len(trimmed):])
+	}
+	if ff, ok := f.(FieldsFormatter); ok {
+		return ff.FormatFields(t, level, message, fields)
+	}
+	return f.Format(t, level, appendFields(message, fields))
 }
 
-// SetFormater sets the current formater to the new one
-func (logger *Logger) SetFormatter(formatter LogFormatter) {
+// EnableLifecycleEvents makes the logger write a "logger_started" event describing its configuration
+// right away, and a "logger_closed" event with the number of written and dropped messages on Close.
+// Lifecycle events are written regardless of the log level.
+func (logger *Logger) EnableLifecycleEvents() {
 	logger.mutex.Lock()
-	logger.formatter = formatter
+	logger.lifecycle = true
 	logger.mutex.Unlock()
+
+	cfg := logger.Config()
+	logger.logEvent("logger_started", fmt.Sprintf("level=%s formatter=%s writer=%s", cfg.LevelName, cfg.Formatter, cfg.Writer))
 }
 
-// Close closes logger. If the log writer implements the io.WriteCloser interface, the logger will close the writer too.
-func (logger *Logger) Close() {
+func (logger *Logger) lifecycleEvents() bool {
 	logger.mutex.Lock()
-	if logger.writeCloser != nil {
-		logger.writeCloser.Close()
-	}
-	logger.mutex.Unlock()
+	defer logger.mutex.Unlock()
+	return logger.lifecycle
 }
 
-// Writer returns current writer of the logger.
-func (logger *Logger) Writer() io.Writer {
-	return logger.writer
+// dropCounter is implemented by writers that may drop messages
+type dropCounter interface {
+	DroppedCount() uint64
 }
 
-func (logger *Logger) Format(t time.Time, level int, message string) string {
-	var msg string
-	logger.mutex.Lock()
-	if logger.formatter != nil {
-		msg = logger.formatter.Format(t, level, message)
+// closeStats describes the messages handled by the logger
+func (logger *Logger) closeStats() string {
+	var dropped uint64
+	if d, ok := logger.Writer().(dropCounter); ok {
+		dropped = d.DroppedCount()
 	}
-	logger.mutex.Unlock()
-	return msg
+	return fmt.Sprintf("messages_written=%d messages_dropped=%d", atomic.LoadUint64(&logger.written), dropped)
+}
+
+// logEvent writes a lifecycle event. Events are not counted as written messages.
+func (logger *Logger) logEvent(event string, details string) {
+	t := time.Now()
+	message := event + " " + details
+	logger.output(LOG_LEVEL_INFO, []byte(logger.Format(t, LOG_LEVEL_INFO, message)), LogRecord{Time: t, Level: LOG_LEVEL_INFO, Message: message})
 }
 
 // Print logs a formatted message at LOG_LEVEL_INFO level
 func (logger *Logger) Print(v ...interface{}) {
-	s := fmt.Sprint(v...)
-	msg := logger.Format(time.Now(), logger.level, s)
-	if logger.Writer() != nil {
-		logger.Writer().Write([]byte(msg))
-	}
+	logger.Log(LOG_LEVEL_INFO, v...)
 }
 
 // Println logs a formatted message at LOG_LEVEL_INFO level
 func (logger *Logger) Println(v ...interface{}) {
-	s := fmt.Sprintln(v...)
-	msg := logger.Format(time.Now(), logger.level, s)
-	if logger.Writer() != nil {
-		logger.Writer().Write([]byte(msg))
-	}
+	logger.Logln(LOG_LEVEL_INFO, v...)
 }
 
-// Println logs a formatted message at LOG_LEVEL_INFO level
+// Printf logs a formatted message at LOG_LEVEL_INFO level
 func (logger *Logger) Printf(format string, v ...interface{}) {
-	s := fmt.Sprintf(format, v...)
-	msg := logger.Format(time.Now(), logger.level, s)
-	if logger.Writer() != nil {
-		logger.Writer().Write([]byte(msg))
+	logger.Logf(LOG_LEVEL_INFO, format, v...)
+}
+
+// MinLeveler is implemented by writers that only accept messages at or above a minimum level. The
+// logger skips formatting messages the writer would not accept.
+type MinLeveler interface {
+	MinLevel() int
+}
+
+// MaxLeveler is implemented by writers that only accept messages at or below a maximum level. The
+// logger skips formatting messages the writer would not accept.
+type MaxLeveler interface {
+	MaxLevel() int
+}
+
+// LevelWriter is implemented by writers that want to know the level of each message, e.g. to route it
+// or to pass it on to a server. The logger calls WriteLevel instead of Write on such writers, and plain
+// Write on all others. The wrapping writers of this package, like AsyncLogWriter, MultiWriter or
+// ThrottleWriter, are LevelWriters passing the level on to the writers they wrap.
+type LevelWriter interface {
+	WriteLevel(level int, p []byte) (n int, err error)
+}
+
+// writeLevel writes p to w, with its level if w is a LevelWriter. A level of 0 means the level is
+// unknown, then p is written with Write.
+func writeLevel(w io.Writer, level int, p []byte) (int, error) {
+	if lw, ok := w.(LevelWriter); ok && level > 0 {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
+}
+
+// RecordWriter is implemented by writers that store messages as structured records rather than lines of
+// text, like a database table. The logger calls WriteRecord instead of Write on such writers, with the
+// time, level, message, merged fields and caller of every message, so the formatter and the prefix of
+// the logger don't matter to them. The logger only passes records to its own writer, not through the
+// writers wrapping a RecordWriter.
+type RecordWriter interface {
+	WriteRecord(r LogRecord) error
+}
+
+// writeMessage writes rec to w if it is a RecordWriter and rec is a record, with a level, and msg with
+// its level otherwise
+func writeMessage(w io.Writer, level int, msg []byte, rec LogRecord) error {
+	if rw, ok := w.(RecordWriter); ok && rec.Level > 0 {
+		return rw.WriteRecord(rec)
+	}
+	_, err := writeLevel(w, level, msg)
+	return err
+}
+
+// acceptsLevel reports whether w accepts messages at the given level
+func acceptsLevel(w io.Writer, level int) bool {
+	if m, ok := w.(MinLeveler); ok && level < m.MinLevel() {
+		return false
+	}
+	if m, ok := w.(MaxLeveler); ok && level > m.MaxLevel() {
+		return false
+	}
+	return true
+}
+
+// enabled reports whether a message at the given level would be written
+func (logger *Logger) enabled(level int) bool {
+	return logger.enabledFields(level, nil)
+}
+
+// enabledFields reports whether a message at the given level with the extra fields would be written,
+// either to the writer or to a tap
+func (logger *Logger) enabledFields(level int, extra Fields) bool {
+	if logger.level.Level() == LOG_LEVEL_OFF {
+		return false
+	}
+	return logger.writable(level, extra) || logger.taps.wants(level)
+}
+
+// writable reports whether a message at the given level with the extra fields would be written to the
+// writer
+func (logger *Logger) writable(level int, extra Fields) bool {
+	return logger.writableAt(level, extra, 0)
+}
+
+// writableAt is writable for a goroutine that is already logging depth messages, see lock
+func (logger *Logger) writableAt(level int, extra Fields, depth int) bool {
+	// the level is checked without locking, filtered messages are the hot path
+	if level < logger.level.Level() {
+		v := logger.verboseField()
+		if v == nil || level < v.level || !(v.matches(logger.fields) || v.matches(extra)) {
+			return false
+		}
+	}
+
+	if !logger.lock(depth) {
+		return false
+	}
+	w := logger.writer
+	logger.mutex.Unlock()
+	if w == io.Discard && logger.hooks.len() == 0 {
+		// nothing would see the message, don't even format it
+		return false
+	}
+	return acceptsLevel(w, level)
+}
+
+// verboseField lowers the level of messages carrying a field, see SetVerboseField
+type verboseField struct {
+	key   string
+	value interface{}
+	level int
+}
+
+// matches reports whether the fields hold the key with the value
+func (v *verboseField) matches(fields Fields) bool {
+	fv, ok := fields[v.key]
+	return ok && reflect.DeepEqual(fv, v.value)
+}
+
+// SetVerboseField lowers the log level to level for messages carrying the field key with the given
+// value. For example SetVerboseField("sampled", true, LOG_LEVEL_DEBUG) logs DEBUG details only for
+// trace-sampled requests. The field is looked up in the fields added with WithFields and in those of
+// a LogRecord. An empty key removes the setting.
+func (logger *Logger) SetVerboseField(key string, value interface{}, level int) {
+	var v *verboseField
+	if key != "" {
+		v = &verboseField{key: key, value: value, level: level}
 	}
+	logger.verbose.Store(v)
+}
+
+// verboseField returns the verbose field setting, or nil if there is none
+func (logger *Logger) verboseField() *verboseField {
+	v, _ := logger.verbose.Load().(*verboseField)
+	return v
 }
 
 // Log logs a formatted message at the given log level
 func (logger *Logger) Log(loglevel int, v ...interface{}) {
-	if loglevel >= logger.level {
+	if logger.enabled(loglevel) {
 		s := fmt.Sprint(v...)
-		msg := logger.Format(time.Now(), loglevel, s)
-		if logger.Writer() != nil {
-			logger.Writer().Write([]byte(msg))
-		}
+		logger.emit(time.Now(), loglevel, s)
 	}
 }
 
 // Logf logs a formatted message at the given log level
 func (logger *Logger) Logf(loglevel int, format string, v ...interface{}) {
-	if loglevel >= logger.level {
-		s := fmt.Sprintf(format, v...)
-		msg := logger.Format(time.Now(), loglevel, s)
-		if logger.Writer() != nil {
-			logger.Writer().Write([]byte(msg))
-		}
+	if logger.enabled(loglevel) {
+		s := sprintf(format, v...)
+		logger.emit(time.Now(), loglevel, s)
+	}
+}
+
+// sprintf formats like fmt.Sprintf, but also accepts the %w verb of fmt.Errorf so that messages like
+// Errorf("open failed: %w", err) render the wrapped error instead of %!w(...)
+func sprintf(format string, v ...interface{}) string {
+	if strings.Contains(format, "%w") {
+		return fmt.Errorf(format, v...).Error()
 	}
+	return fmt.Sprintf(format, v...)
 }
 
-// Logln logs a formatted message at the given log level
+// Logln logs a formatted message at the given log level. The operands are always separated by spaces,
+// like fmt.Sprintln, but the message doesn't end in a newline of its own: the formatter ends the line.
 func (logger *Logger) Logln(loglevel int, v ...interface{}) {
-	if loglevel >= logger.level {
-		s := fmt.Sprintln(v...)
-		msg := logger.Format(time.Now(), loglevel, s)
-		if logger.Writer() != nil {
-			logger.Writer().Write([]byte(msg))
+	if logger.enabled(loglevel) {
+		s := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+		logger.emit(time.Now(), loglevel, s)
+	}
+}
+
+// DEFAULT_STACK_DEPTH is the default number of frames logged by Stack
+const DEFAULT_STACK_DEPTH = 32
+
+// SetStackDepth sets the maximum number of frames logged by Stack
+func (logger *Logger) SetStackDepth(depth int) {
+	logger.mutex.Lock()
+	logger.stackDepth = depth
+	logger.mutex.Unlock()
+}
+
+// SetReportCaller makes the logger report the file and line of the code that logged each message
+func (logger *Logger) SetReportCaller(enabled bool) {
+	logger.mutex.Lock()
+	logger.reportCaller = enabled
+	logger.mutex.Unlock()
+}
+
+// packagePath is the import path of this package, whose frames are skipped when looking for the caller
+var packagePath = func() string {
+	name := runtime.FuncForPC(reflect.ValueOf(New).Pointer()).Name()
+	return name[:strings.LastIndex(name, ".")]
+}()
+
+// callerOutside returns "file.go:42" of the first caller outside of this package. Walking the frames
+// instead of skipping a fixed number of them works the same through every convenience method.
+func callerOutside() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePath+".") {
+			return fmt.Sprintf("%s:%d", path.Base(frame.File), frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// Stack logs msg at the given log level followed by the call stack of the caller
+func (logger *Logger) Stack(level int, msg string) {
+	if !logger.enabled(level) {
+		return
+	}
+
+	logger.mutex.Lock()
+	depth := logger.stackDepth
+	logger.mutex.Unlock()
+	if depth <= 0 {
+		depth = DEFAULT_STACK_DEPTH
+	}
+
+	// skip runtime.Callers and Stack itself
+	pcs := make([]uintptr, depth)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var buf bytes.Buffer
+	buf.WriteString(msg)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "\n\t%s\n\t\t%s:%d", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	logger.emit(time.Now(), level, buf.String())
+}
+
+// LogRecord is a fully built log message, for adapters bridging other logging APIs to a Logger
+type LogRecord struct {
+	Time    time.Time // the current time is used if zero
+	Level   int
+	Message string
+	Fields  Fields // merged with the fields of the logger
+	Caller  string // "file.go:42", looked up if empty and the logger reports callers
+}
+
+// LogRecord formats and writes a pre-built record, subject to the logger's level like Log
+func (logger *Logger) LogRecord(r LogRecord) {
+	if logger.enabledFields(r.Level, r.Fields) {
+		if r.Time.IsZero() {
+			r.Time = time.Now()
 		}
+		logger.emitFields(r.Time, r.Level, r.Message, r.Fields, r.Caller)
+	}
+}
+
+// Logfs logs a formatted message at the given log level and returns the message. The message is returned
+// even if the level is filtered out, so it can be reused, e.g. in an error response.
+func (logger *Logger) Logfs(loglevel int, format string, v ...interface{}) string {
+	s := sprintf(format, v...)
+	if logger.enabled(loglevel) {
+		logger.emit(time.Now(), loglevel, s)
 	}
+	return s
 }
 
 // Trace logs a formatted message at log level: LOG_LEVEL_TRACE
@@ -285,6 +2117,11 @@ func (logger *Logger) Traceln(v ...interface{}) {
 	logger.Logln(LOG_LEVEL_TRACE, v...)
 }
 
+// Tracefs logs a formatted message at log level: LOG_LEVEL_TRACE and returns the message
+func (logger *Logger) Tracefs(format string, v ...interface{}) string {
+	return logger.Logfs(LOG_LEVEL_TRACE, format, v...)
+}
+
 // Debug logs a formatted message at log level: LOG_LEVEL_DEBUG
 func (logger *Logger) Debug(v ...interface{}) {
 	logger.Log(LOG_LEVEL_DEBUG, v...)
@@ -300,6 +2137,11 @@ func (logger *Logger) Debugln(v ...interface{}) {
 	logger.Logln(LOG_LEVEL_DEBUG, v...)
 }
 
+// Debugfs logs a formatted message at log level: LOG_LEVEL_DEBUG and returns the message
+func (logger *Logger) Debugfs(format string, v ...interface{}) string {
+	return logger.Logfs(LOG_LEVEL_DEBUG, format, v...)
+}
+
 // Info logs a formatted message at log level: LOG_LEVEL_INFO
 func (logger *Logger) Info(v ...interface{}) {
 	logger.Log(LOG_LEVEL_INFO, v...)
@@ -315,6 +2157,11 @@ func (logger *Logger) Infoln(v ...interface{}) {
 	logger.Logln(LOG_LEVEL_INFO, v...)
 }
 
+// Infofs logs a formatted message at log level: LOG_LEVEL_INFO and returns the message
+func (logger *Logger) Infofs(format string, v ...interface{}) string {
+	return logger.Logfs(LOG_LEVEL_INFO, format, v...)
+}
+
 // Warn logs a formatted message at log level: LOG_LEVEL_WARN
 func (logger *Logger) Warn(v ...interface{}) {
 	logger.Log(LOG_LEVEL_WARN, v...)
@@ -330,6 +2177,11 @@ func (logger *Logger) Warnln(v ...interface{}) {
 	logger.Logln(LOG_LEVEL_WARN, v...)
 }
 
+// Warnfs logs a formatted message at log level: LOG_LEVEL_WARN and returns the message
+func (logger *Logger) Warnfs(format string, v ...interface{}) string {
+	return logger.Logfs(LOG_LEVEL_WARN, format, v...)
+}
+
 // Error logs a formatted message at log level: LOG_LEVEL_ERROR
 func (logger *Logger) Error(v ...interface{}) {
 	logger.Log(LOG_LEVEL_ERROR, v...)
@@ -345,59 +2197,144 @@ func (logger *Logger) Errorln(v ...interface{}) {
 	logger.Logln(LOG_LEVEL_ERROR, v...)
 }
 
+// Errorfs logs a formatted message at log level: LOG_LEVEL_ERROR and returns the message
+func (logger *Logger) Errorfs(format string, v ...interface{}) string {
+	return logger.Logfs(LOG_LEVEL_ERROR, format, v...)
+}
+
+// exit terminates the program on Fatal, tests replace it to observe the exit code
+var exit = os.Exit
+
+// SetDumpGoroutines enables writing a full goroutine stack dump to the log on Fatal and Panic, before
+// the program exits or panics.
+func (logger *Logger) SetDumpGoroutines(enabled bool) {
+	logger.mutex.Lock()
+	logger.dumpStacks = enabled
+	logger.mutex.Unlock()
+}
+
+// dumpGoroutines writes the stacks of all goroutines to the log if enabled by SetDumpGoroutines
+func (logger *Logger) dumpGoroutines() {
+	logger.mutex.Lock()
+	enabled := logger.dumpStacks
+	logger.mutex.Unlock()
+	if !enabled {
+		return
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	if !logger.writable(LOG_LEVEL_FATAL, nil) {
+		return
+	}
+
+	// the dump goes straight to the writer: the sampler or rate limiter must not drop it, nor the hooks
+	// and taps see it as a message
+	logger.mutex.Lock()
+	fields, prefix, onError := logger.fields, logger.prefix, logger.onError
+	logger.mutex.Unlock()
+	t := time.Now()
+	message := "goroutine dump:\n" + string(buf)
+	out := getBuffer()
+	defer putBuffer(out)
+	out.WriteString(prefix)
+	logger.formatTo(out, t, LOG_LEVEL_FATAL, message, fields, "")
+	logger.write(LOG_LEVEL_FATAL, out.Bytes(), LogRecord{Time: t, Level: LOG_LEVEL_FATAL, Message: message, Fields: fields}, onError)
+}
+
 // Fatal logs a formatted message at log level: LOG_LEVEL_FATAL then calls os.Exit(1)
 func (logger *Logger) Fatal(v ...interface{}) {
 	logger.Log(LOG_LEVEL_FATAL, v...)
-	os.Exit(1)
+	logger.dumpGoroutines()
+	logger.closeWriter()
+	exit(1)
 }
 
 // Fatalf logs a formatted message at log level: LOG_LEVEL_FATAL then calls os.Exit(1)
 func (logger *Logger) Fatalf(format string, v ...interface{}) {
 	logger.Logf(LOG_LEVEL_FATAL, format, v...)
-	if logger.writeCloser != nil {
-		logger.writeCloser.Close()
-	}
-	os.Exit(1)
+	logger.dumpGoroutines()
+	logger.closeWriter()
+	exit(1)
 }
 
 // Panic logs a formatted message at log level: LOG_LEVEL_FATAL then calls os.Exit(1)
 func (logger *Logger) Fatalln(v ...interface{}) {
 	logger.Logln(LOG_LEVEL_FATAL, v...)
-	if logger.writeCloser != nil {
-		logger.writeCloser.Close()
-	}
-	os.Exit(1)
+	logger.dumpGoroutines()
+	logger.closeWriter()
+	exit(1)
 }
 
 // Panic logs a message at log level: LOG_LEVEL_FATAL then calls panic()
 func (logger *Logger) Panic(v ...interface{}) {
 	logger.Log(LOG_LEVEL_FATAL, v...)
-	if logger.writeCloser != nil {
-		logger.writeCloser.Close()
-	}
+	logger.dumpGoroutines()
+	logger.closeWriter()
 	panic(nil)
 }
 
 // Panicf logs a formatted message at log level: LOG_LEVEL_FATAL then calls panic()
 func (logger *Logger) Panicf(format string, v ...interface{}) {
 	logger.Logf(LOG_LEVEL_FATAL, format, v...)
-	if logger.writeCloser != nil {
-		logger.writeCloser.Close()
-	}
+	logger.dumpGoroutines()
+	logger.closeWriter()
 	panic(nil)
 }
 
 // Panicln logs a formatted message at log level: LOG_LEVEL_FATAL then calls panic()
 func (logger *Logger) Panicln(v ...interface{}) {
 	logger.Logln(LOG_LEVEL_FATAL, v...)
-	if logger.writeCloser != nil {
-		logger.writeCloser.Close()
-	}
+	logger.dumpGoroutines()
+	logger.closeWriter()
 	panic(nil)
 }
 
+// SeverityFunc maps a log level to the severity scale of a particular sink. Sinks that talk to systems
+// with their own scale (syslog, OpenTelemetry, ...) take a SeverityFunc so the translation is done in
+// one place.
+type SeverityFunc func(level int) int
+
+// SyslogSeverity maps a log level to a syslog severity as defined by RFC 5424 (0 = emergency, 7 = debug)
+func SyslogSeverity(level int) int {
+	switch level {
+	case LOG_LEVEL_TRACE, LOG_LEVEL_DEBUG:
+		return 7
+	case LOG_LEVEL_INFO:
+		return 6
+	case LOG_LEVEL_WARN:
+		return 4
+	case LOG_LEVEL_ERROR:
+		return 3
+	case LOG_LEVEL_FATAL:
+		return 2
+	default:
+		return 5
+	}
+}
+
 // LogLevel2String returns the string format of the given loglevel enum
 func LogLevel2String(level int) string {
+	if name := builtinLevelName(level); name != "" {
+		return name
+	}
+	customLevels.RLock()
+	defer customLevels.RUnlock()
+	if name, ok := customLevels.names[level]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// builtinLevelName returns the name of a built-in level, or "" if level isn't one
+func builtinLevelName(level int) string {
 	switch level {
 	case LOG_LEVEL_TRACE:
 		return "TRACE"
@@ -411,13 +2348,28 @@ func LogLevel2String(level int) string {
 		return "ERROR"
 	case LOG_LEVEL_FATAL:
 		return "FATAL"
-	default:
-		return "Unknown"
+	case LOG_LEVEL_OFF:
+		return "OFF"
 	}
+	return ""
 }
 
+// String2LogLevel returns the loglevel enum of the given string, or -1 if the string is not a known level
 func String2LogLevel(str string) int {
 	str = strings.ToUpper(str)
+	if level := builtinLevel(str); level >= 0 {
+		return level
+	}
+	customLevels.RLock()
+	defer customLevels.RUnlock()
+	if level, ok := customLevels.values[str]; ok {
+		return level
+	}
+	return -1
+}
+
+// builtinLevel returns the built-in level of an upper case name, or -1 if there is none
+func builtinLevel(str string) int {
 	switch str {
 	case "TRACE":
 		return LOG_LEVEL_TRACE
@@ -425,13 +2377,44 @@ func String2LogLevel(str string) int {
 		return LOG_LEVEL_DEBUG
 	case "INFO":
 		return LOG_LEVEL_INFO
-	case "WARN":
+	case "WARN", "WARNING":
 		return LOG_LEVEL_WARN
 	case "ERROR":
-		return LOG_LEVEL_WARN
+		return LOG_LEVEL_ERROR
 	case "FATAL":
 		return LOG_LEVEL_FATAL
-	default:
-		return -1
+	case "OFF", "SILENT":
+		return LOG_LEVEL_OFF
+	}
+	return -1
+}
+
+// customLevels holds the levels registered with RegisterLevel
+var customLevels = struct {
+	sync.RWMutex
+	names  map[int]string
+	values map[string]int
+}{names: map[int]string{}, values: map[string]int{}}
+
+// RegisterLevel adds a level with a custom name, which LogLevel2String and String2LogLevel then know
+// like the built-in ones. Levels are plain numbers: a message is written if its level is at least the
+// level of the logger, whatever the names. The built-in levels are 10 apart, from LOG_LEVEL_TRACE (10)
+// to LOG_LEVEL_FATAL (60), so a custom level can rank below TRACE (1 to 9), between two built-in
+// levels, e.g. NOTICE at 35 between INFO and WARN, or above FATAL. The value must be positive and below
+// LOG_LEVEL_OFF, and neither it nor the name, which is case insensitive, may be taken by another level.
+func RegisterLevel(value int, name string) error {
+	upper := strings.ToUpper(name)
+	if value < 1 || value >= LOG_LEVEL_OFF || name == "" {
+		return fmt.Errorf("log: invalid level %d %q", value, name)
+	}
+	customLevels.Lock()
+	defer customLevels.Unlock()
+	_, valueTaken := customLevels.names[value]
+	_, nameTaken := customLevels.values[upper]
+	if valueTaken || nameTaken || builtinLevelName(value) != "" || builtinLevel(upper) >= 0 {
+		return fmt.Errorf("log: level %d %q is already registered", value, name)
 	}
+	customLevels.names[value] = name
+	customLevels.values[upper] = value
+	return nil
 }
@@ -0,0 +1,291 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDumpGoroutinesOnFatal(t *testing.T) {
+	code := 0
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	// park another goroutine so the dump has more than one stack
+	block := make(chan int)
+	defer close(block)
+	go func() { <-block }()
+
+	var buf bytes.Buffer
+	logger := New(&buf, LOG_LEVEL_INFO)
+	logger.SetDumpGoroutines(true)
+	logger.Fatal("fatal error")
+
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "fatal error") || !strings.Contains(out, "goroutine dump:") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if n := strings.Count(out, "goroutine "); n < 3 {
+		t.Errorf("dump contains %d goroutine stacks, want several", n-1)
+	}
+}
+
+func TestDumpGoroutinesBypassesRateLimit(t *testing.T) {
+	exit = func(int) {}
+	defer func() { exit = os.Exit }()
+
+	// the fatal message takes the only token of the rate limit, the dump is written anyway
+	var buf bytes.Buffer
+	logger := New(&buf, LOG_LEVEL_INFO)
+	logger.SetRateLimit(1, 1)
+	logger.SetDumpGoroutines(true)
+	logger.Fatal("fatal error")
+
+	if out := buf.String(); !strings.Contains(out, "fatal error") || !strings.Contains(out, "goroutine dump:") {
+		t.Errorf("unexpected output with a rate limit: %q", out)
+	}
+	if n := logger.DroppedByRateLimit(); n != 0 {
+		t.Errorf("%d messages dropped by the rate limit", n)
+	}
+}
+
+type recordingWriter struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.Write(data)
+}
+
+func (w *recordingWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.String()
+}
+
+func TestHandleShutdown(t *testing.T) {
+	rec := &recordingWriter{}
+	logger := New(NewAsyncLogWriter(rec, DEFAULT_QUEUE_SIZE), LOG_LEVEL_INFO)
+	for i := 0; i < 50; i++ {
+		logger.Infof("Message #%d", i)
+	}
+
+	ch := make(chan os.Signal, 1)
+	raised := make(chan os.Signal, 1)
+	go logger.handleShutdown(ch, make(chan int), time.Second, func(sig os.Signal) { raised <- sig })
+	ch <- syscall.SIGTERM
+
+	select {
+	case sig := <-raised:
+		if sig != syscall.SIGTERM {
+			t.Errorf("re-raised %v, want SIGTERM", sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was not re-raised")
+	}
+	if n := strings.Count(rec.String(), "\n"); n != 50 {
+		t.Errorf("%d messages written before re-raising the signal, want 50", n)
+	}
+}
+
+func TestDailyFileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2014, 5, 1, 23, 59, 59, 0, time.UTC)
+	w, err := NewDailyFileWriter(dir, "app", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return now }
+	w.SetHeader("# daily")
+
+	w.Write([]byte("before midnight\n"))
+	now = now.Add(2 * time.Second)
+	w.Write([]byte("after midnight\n"))
+	w.Write([]byte("still the same day\n"))
+	w.Close()
+
+	for name, want := range map[string]string{
+		"app-2014-05-01.log": "# daily\nbefore midnight\n",
+		"app-2014-05-02.log": "# daily\nafter midnight\nstill the same day\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s contains %q (%v), want %q", name, data, err, want)
+		}
+	}
+}
+
+func TestDailyFileWriterLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 22:30 UTC is already the next day two hours east
+	w, err := NewDailyFileWriterIn(dir, "app", time.FixedZone("UTC+2", 2*60*60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return time.Date(2014, 5, 1, 22, 30, 0, 0, time.UTC) }
+	w.Write([]byte("late\n"))
+	w.Close()
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "app-2014-05-02.log")); err != nil || string(data) != "late\n" {
+		t.Errorf("app-2014-05-02.log contains %q (%v)", data, err)
+	}
+}
+
+func TestDailyFileLoggerIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the timestamps follow local file dates, other zones only change the file dates
+	for _, tt := range []struct {
+		loc   *time.Location
+		local bool
+	}{
+		{time.UTC, false},
+		{time.Local, true},
+		{time.FixedZone("UTC+2", 2*60*60), false},
+	} {
+		logger, err := NewDailyFileLoggerIn(dir, "app", LOG_LEVEL_INFO, tt.loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Close()
+		if f, ok := logger.formatter.(*DefaultLogFormatter); !ok || f.Local != tt.local {
+			t.Errorf("formatter for days in %v is %+v, want Local %v", tt.loc, logger.formatter, tt.local)
+		}
+	}
+}
+
+func TestDailyFileWriterOpenFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2014, 5, 1, 23, 59, 59, 0, time.UTC)
+	w, err := NewDailyFileWriter(dir, "app", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.now = func() time.Time { return now }
+
+	// a directory in place of the next day's file makes opening it fail
+	blocker := filepath.Join(dir, "app-2014-05-02.log")
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Second)
+	if _, err := w.Write([]byte("lost\n")); err == nil {
+		t.Error("Write succeeded without a file")
+	}
+	if err := w.Sync(); err != os.ErrClosed {
+		t.Errorf("Sync without a file returned %v, want os.ErrClosed", err)
+	}
+
+	os.Remove(blocker)
+	if _, err := w.Write([]byte("recovered\n")); err != nil {
+		t.Fatalf("Write after the file could be opened again: %v", err)
+	}
+	if data, err := ioutil.ReadFile(blocker); err != nil || string(data) != "recovered\n" {
+		t.Errorf("app-2014-05-02.log contains %q (%v)", data, err)
+	}
+}
+
+// closeCounter counts how often it is closed
+type closeCounter struct {
+	bytes.Buffer
+	closes int
+}
+
+func (w *closeCounter) Close() error {
+	w.closes++
+	return nil
+}
+
+func TestCloseOnce(t *testing.T) {
+	exit = func(int) {}
+	defer func() { exit = os.Exit }()
+
+	w := &closeCounter{}
+	logger := New(w, LOG_LEVEL_INFO)
+	logger.Close()
+	logger.Close()
+	logger.Fatalf("fatal %d", 1)
+	func() {
+		defer func() { recover() }()
+		logger.Panic("panic")
+	}()
+	if w.closes != 1 {
+		t.Errorf("writer closed %d times, want 1", w.closes)
+	}
+
+	// a new writer is closed again
+	w2 := &closeCounter{}
+	logger.SetWriterWithClose(w2, w2.Close)
+	logger.Close()
+	logger.Close()
+	if w.closes != 1 || w2.closes != 1 {
+		t.Errorf("writers closed %d and %d times, want 1 each", w.closes, w2.closes)
+	}
+}
+
+// syncRecorder counts how often it is synced
+type syncRecorder struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (w *syncRecorder) Sync() error {
+	w.syncs++
+	return nil
+}
+
+func TestUnclosableStream(t *testing.T) {
+	logger, err := NewFromConfig(LogConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the wrapped stderr is still recognized as stderr when its pipe breaks
+	if w := DefaultBrokenPipeHandler(logger.writer, syscall.EPIPE); w != io.Discard {
+		t.Errorf("broken stderr from the config replaced by %v, want io.Discard", w)
+	}
+
+	inner := &syncRecorder{}
+	logger = New(unclosable{inner}, LOG_LEVEL_INFO)
+	logger.Info("synced")
+	if err := logger.Sync(); err != nil || inner.syncs != 1 {
+		t.Errorf("Sync returned %v and synced the stream %d times, want once", err, inner.syncs)
+	}
+	logger.Close()
+	logger.Info("still writable")
+	if n := strings.Count(inner.String(), "\n"); n != 2 {
+		t.Errorf("%d messages written around Close, want 2", n)
+	}
+}
@@ -2,10 +2,23 @@ package log_test
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	stdlog "log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -184,3 +197,2068 @@ func BenchmarkHTTPLogger(b *testing.B) {
 	// Wait for 5 seconds to make sure the messages have reached the server
 	stopLogServerAfter(5)
 }
+
+func TestFlushPropagates(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		stack func(file *os.File) io.Writer
+		read  func(data []byte) (string, error)
+	}{
+		{
+			// async -> buffered -> file
+			name:  "bufio",
+			stack: func(file *os.File) io.Writer { return bufio.NewWriterSize(file, 4096) },
+			read:  func(data []byte) (string, error) { return string(data), nil },
+		},
+		{
+			// async -> BufferedWriter -> gzip -> file, read back while the gzip stream is still open
+			name: "gzip",
+			stack: func(file *os.File) io.Writer {
+				return log.NewBufferedWriter(log.NewGzipFileWriter(file), 4096, 0)
+			},
+			read: func(data []byte) (string, error) {
+				r, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					return "", err
+				}
+				text, err := ioutil.ReadAll(r)
+				// the stream has no trailer before Close, only the data up to the last flush
+				if err == io.ErrUnexpectedEOF {
+					err = nil
+				}
+				return string(text), err
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "log_flush")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(file.Name())
+			defer file.Close()
+
+			w := log.NewAsyncLogWriter(tt.stack(file), log.DEFAULT_QUEUE_SIZE)
+			logger := log.New(w, log.LOG_LEVEL_DEBUG)
+			for i := 0; i < 10; i++ {
+				logger.Infof("Message #%d", i)
+			}
+
+			if _, err := logger.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(file.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := tt.read(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(text, "\n"); n != 10 {
+				t.Errorf("found %d lines in the file after Flush, want 10", n)
+			}
+		})
+	}
+}
+
+func TestNewWithFallback(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := log.NewWithFallback(log.LOG_LEVEL_INFO,
+		func() (io.Writer, error) { return nil, errors.New("permission denied") },
+		func() (io.Writer, error) { return &buf, nil },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Writer() != &buf {
+		t.Fatalf("logger writes to %T, want the fallback writer", logger.Writer())
+	}
+	logger.Info("hello")
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("fallback writer got %q", buf.String())
+	}
+
+	_, err = log.NewWithFallback(log.LOG_LEVEL_INFO,
+		func() (io.Writer, error) { return nil, errors.New("permission denied") },
+	)
+	if err == nil {
+		t.Error("expected an error when every factory fails")
+	}
+}
+
+type upperFormatter struct{}
+
+func (f *upperFormatter) Format(t time.Time, level int, message string) string {
+	return strings.ToUpper(message) + "\n"
+}
+
+func TestConfig(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_DEBUG)
+	logger.SetLogLevel(log.LOG_LEVEL_WARN)
+	logger.SetFormatter(&upperFormatter{})
+
+	cfg := logger.Config()
+	if cfg.Level != log.LOG_LEVEL_WARN || cfg.LevelName != "WARN" {
+		t.Errorf("config level = %d %q, want WARN", cfg.Level, cfg.LevelName)
+	}
+	if cfg.Formatter != "*log_test.upperFormatter" {
+		t.Errorf("config formatter = %q", cfg.Formatter)
+	}
+	if cfg.Writer != "*bytes.Buffer" {
+		t.Errorf("config writer = %q", cfg.Writer)
+	}
+}
+
+type brokenPipeWriter struct {
+	writes int
+}
+
+func (w *brokenPipeWriter) Write(data []byte) (int, error) {
+	w.writes++
+	return 0, &os.PathError{Op: "write", Path: "|1", Err: syscall.EPIPE}
+}
+
+// brokenPipeSlice is a broken pipe writer of an uncomparable type
+type brokenPipeSlice []int
+
+func (w brokenPipeSlice) Write(data []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "|1", Err: syscall.EPIPE}
+}
+
+func TestBrokenPipeUncomparable(t *testing.T) {
+	logger := log.New(brokenPipeSlice{1}, log.LOG_LEVEL_INFO)
+	var buf bytes.Buffer
+	logger.SetBrokenPipeHandler(func(w io.Writer, err error) io.Writer {
+		return &buf
+	})
+	logger.Info("hello")
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("message not written to the replacement writer: %q", buf.String())
+	}
+}
+
+func TestBrokenPipe(t *testing.T) {
+	pipe := &brokenPipeWriter{}
+	logger := log.New(pipe, log.LOG_LEVEL_INFO)
+
+	var buf bytes.Buffer
+	calls := 0
+	logger.SetBrokenPipeHandler(func(w io.Writer, err error) io.Writer {
+		calls++
+		if w != pipe {
+			t.Errorf("handler got writer %T, want the broken pipe", w)
+		}
+		return &buf
+	})
+
+	logger.Info("first")
+	logger.Info("second")
+
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+	if pipe.writes != 1 {
+		t.Errorf("broken pipe written %d times, want 1", pipe.writes)
+	}
+	if !strings.Contains(buf.String(), "first") || !strings.Contains(buf.String(), "second") {
+		t.Errorf("logging didn't continue on the new writer: %q", buf.String())
+	}
+}
+
+func TestBrokenPipeHandlerLogs(t *testing.T) {
+	pipe := &brokenPipeWriter{}
+	logger := log.New(pipe, log.LOG_LEVEL_INFO)
+	var errs []error
+	logger.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	// the handler logs and configures the logger, which would deadlock if it held the mutex
+	replacement, other := &lineRecorder{}, &lineRecorder{}
+	calls := 0
+	logger.SetBrokenPipeHandler(func(w io.Writer, err error) io.Writer {
+		calls++
+		logger.Warn("switching writers")
+		logger.SetLogLevel(log.LOG_LEVEL_WARN)
+		logger.SetWriter(replacement)
+		return other
+	})
+
+	done := make(chan int)
+	go func() {
+		defer close(done)
+		logger.Warn("first")
+		logger.Info("filtered")
+		logger.Warn("second")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging from the broken pipe handler deadlocked")
+	}
+
+	// the writer set by the handler wins over the one it returned
+	if lines := replacement.Lines(); calls != 1 || len(lines) != 2 || !strings.Contains(lines[0], "first") || !strings.Contains(lines[1], "second") {
+		t.Errorf("handler called %d times, replacement got %q", calls, lines)
+	}
+	if len(other.Lines()) != 0 {
+		t.Errorf("the returned writer got %q after the handler set another one", other.Lines())
+	}
+	// the handler's own message failed on the broken pipe
+	if len(errs) != 1 || !errors.Is(errs[0], syscall.EPIPE) {
+		t.Errorf("errors = %v, want the handler's message failing with EPIPE", errs)
+	}
+}
+
+type lineRecorder struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (r *lineRecorder) Write(data []byte) (int, error) {
+	r.mutex.Lock()
+	r.lines = append(r.lines, string(data))
+	r.mutex.Unlock()
+	return len(data), nil
+}
+
+func (r *lineRecorder) Lines() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+func TestAsyncLogWriterBufferReuse(t *testing.T) {
+	rec := &lineRecorder{}
+	w := log.NewAsyncLogWriter(rec, 10)
+
+	// every producer reuses a single buffer for all of its writes
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			buf := make([]byte, 0, 64)
+			for i := 0; i < 200; i++ {
+				buf = append(buf[:0], fmt.Sprintf("producer %d message %d\n", g, i)...)
+				w.Write(buf)
+			}
+		}(g)
+	}
+	wg.Wait()
+	w.Close()
+
+	seen := map[string]bool{}
+	for _, line := range rec.Lines() {
+		seen[line] = true
+	}
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 200; i++ {
+			if line := fmt.Sprintf("producer %d message %d\n", g, i); !seen[line] {
+				t.Fatalf("%q was not written intact", line)
+			}
+		}
+	}
+}
+
+func BenchmarkAsyncLogWriter(b *testing.B) {
+	w := log.NewAsyncLogWriter(ioutil.Discard, 1000)
+	data := []byte("INFO: 2006-01-02T15:04:05 (UTC): This is a testing message.\n")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Write(data)
+	}
+	w.Close()
+}
+
+type gatedWriter struct {
+	lineRecorder
+	gate chan int
+}
+
+func (w *gatedWriter) Write(data []byte) (int, error) {
+	<-w.gate
+	return w.lineRecorder.Write(data)
+}
+
+func TestAsyncLogWriterCopiesData(t *testing.T) {
+	inner := &gatedWriter{gate: make(chan int)}
+	w := log.NewAsyncLogWriter(inner, log.DEFAULT_QUEUE_SIZE)
+
+	buf := []byte("hello\n")
+	w.Write(buf)
+	copy(buf, "XXXXX\n") // the message is still queued because the inner writer is gated
+
+	close(inner.gate)
+	w.Close()
+
+	if lines := inner.Lines(); len(lines) != 1 || lines[0] != "hello\n" {
+		t.Errorf("written %q, want the data as it was at Write", lines)
+	}
+}
+
+func TestSetWriterWithClose(t *testing.T) {
+	logger := log.New(ioutil.Discard, log.LOG_LEVEL_INFO)
+
+	var buf bytes.Buffer
+	var steps []string
+	logger.SetWriterWithClose(&buf, func() error {
+		steps = append(steps, "flush", "close")
+		return nil
+	})
+	logger.Info("hello")
+	logger.Close()
+
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("message not written to the new writer: %q", buf.String())
+	}
+	if len(steps) != 2 {
+		t.Errorf("close func ran %v, want it to run once on Close", steps)
+	}
+}
+
+// severitySink records the severity of every message on its own scale
+type severitySink struct {
+	severity   log.SeverityFunc
+	severities []int
+}
+
+func (s *severitySink) Format(t time.Time, level int, message string) string {
+	s.severities = append(s.severities, s.severity(level))
+	return message
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	sink := &severitySink{severity: log.SyslogSeverity}
+	logger := log.New(&lineRecorder{}, log.LOG_LEVEL_TRACE)
+	logger.SetFormatter(sink)
+
+	logger.Trace("trace")
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	want := []int{7, 7, 6, 4, 3}
+	if fmt.Sprint(sink.severities) != fmt.Sprint(want) {
+		t.Errorf("mapped severities = %v, want %v", sink.severities, want)
+	}
+}
+
+func TestErrorfs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+
+	msg := logger.Errorfs("user %d not found", 42)
+	if msg != "user 42 not found" {
+		t.Errorf("returned %q", msg)
+	}
+	if !strings.HasSuffix(buf.String(), ": "+msg+"\n") {
+		t.Errorf("logged %q, want it to end with the returned message", buf.String())
+	}
+
+	// filtered messages are still returned
+	buf.Reset()
+	if msg := logger.Debugfs("cache %s", "miss"); msg != "cache miss" || buf.Len() != 0 {
+		t.Errorf("Debugfs returned %q and logged %q", msg, buf.String())
+	}
+}
+
+func TestAsyncLogWriterOverflow(t *testing.T) {
+	inner := &gatedWriter{gate: make(chan int)}
+	overflow := &lineRecorder{}
+	w := log.NewAsyncLogWriter(inner, 2)
+	w.SetOverflowWriter(overflow)
+
+	// the inner writer is stuck, so at most three messages fit: one being written and two queued
+	for i := 0; i < 10; i++ {
+		w.Write([]byte(fmt.Sprintf("Message #%d\n", i)))
+	}
+	close(inner.gate)
+	w.Close()
+
+	if n := len(overflow.Lines()); n < 7 {
+		t.Errorf("overflow writer got %d messages, want at least 7", n)
+	}
+	if n := len(inner.Lines()) + len(overflow.Lines()); n != 10 {
+		t.Errorf("%d messages written in total, want 10", n)
+	}
+}
+
+func TestAsyncLogWriterHighWater(t *testing.T) {
+	inner := &gatedWriter{gate: make(chan int)}
+	w := log.NewAsyncLogWriter(inner, 10)
+	var calls [][2]int
+	w.OnHighWater(5, func(len, cap int) {
+		calls = append(calls, [2]int{len, cap})
+	})
+
+	// the inner writer is stuck, so the queue fills up
+	for i := 0; i < 9; i++ {
+		w.Write([]byte(fmt.Sprintf("Message #%d\n", i)))
+	}
+	if n := w.QueueLen(); n < 8 || w.QueueCap() != 10 {
+		t.Errorf("queue holds %d of %d messages, want at least 8 of 10", n, w.QueueCap())
+	}
+	if len(calls) != 1 || calls[0][0] != 5 || calls[0][1] != 10 {
+		t.Errorf("high watermark calls = %v, want a single call at 5 of 10", calls)
+	}
+
+	close(inner.gate)
+	w.Close()
+	if n := w.QueueLen(); n != 0 {
+		t.Errorf("%d messages left in the queue after Close", n)
+	}
+}
+
+func TestAsyncLogWriterCloseWithTimeout(t *testing.T) {
+	w := log.NewAsyncLogWriter(&lineRecorder{}, 10)
+	w.Write([]byte("hello\n"))
+	if n, err := w.CloseWithTimeout(time.Second); n != 0 || err != nil {
+		t.Errorf("CloseWithTimeout = %d, %v, want everything drained", n, err)
+	}
+
+	inner := &gatedWriter{gate: make(chan int)}
+	w = log.NewAsyncLogWriter(inner, 10)
+	for i := 0; i < 5; i++ {
+		w.Write([]byte(fmt.Sprintf("Message #%d\n", i)))
+	}
+	// wait for the background goroutine to get stuck on the first message
+	for w.QueueLen() > 4 {
+		time.Sleep(time.Millisecond)
+	}
+	n, err := w.CloseWithTimeout(20 * time.Millisecond)
+	if n != 4 || err != log.ErrCloseTimeout {
+		t.Errorf("CloseWithTimeout = %d, %v, want 4 undrained messages and ErrCloseTimeout", n, err)
+	}
+	if _, err := w.Write([]byte("late\n")); err != os.ErrClosed {
+		t.Errorf("Write after CloseWithTimeout = %v, want os.ErrClosed", err)
+	}
+
+	// the stuck write finishes, then the background goroutine exits
+	close(inner.gate)
+	w.Close()
+	if lines := inner.Lines(); len(lines) != 1 {
+		t.Errorf("written %q, want only the message that was being written", lines)
+	}
+}
+
+func TestAsyncLogWriterCloseFullQueue(t *testing.T) {
+	inner := &gatedWriter{gate: make(chan int)}
+	w := log.NewAsyncLogWriter(inner, 2)
+	w.Write([]byte("first\n"))
+	for w.QueueLen() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// the first message is stuck in the writer, two more fill the queue and the last one blocks
+	w.Write([]byte("second\n"))
+	w.Write([]byte("third\n"))
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("fourth\n"))
+		blocked <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan int)
+	go func() {
+		defer close(closed)
+		if n, err := w.CloseWithTimeout(50 * time.Millisecond); n != 2 || err != log.ErrCloseTimeout {
+			t.Errorf("CloseWithTimeout = %d, %v, want 2 undrained messages and ErrCloseTimeout", n, err)
+		}
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("CloseWithTimeout is blocked by a write waiting for room in the queue")
+	}
+	if err := <-blocked; err != os.ErrClosed {
+		t.Errorf("blocked Write = %v, want os.ErrClosed", err)
+	}
+	close(inner.gate)
+	w.Close()
+}
+
+func TestLogRecord(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.UTC)
+	logger.LogRecord(log.LogRecord{Time: ts, Level: log.LOG_LEVEL_WARN, Message: "bridged message"})
+	logger.LogRecord(log.LogRecord{Time: ts, Level: log.LOG_LEVEL_DEBUG, Message: "filtered"})
+
+	if want := "WARN: 2014-05-01T12:30:00 (UTC): bridged message\n"; buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	logger.WithFields(log.Fields{"service": "api"}).LogRecord(log.LogRecord{Time: ts, Level: log.LOG_LEVEL_INFO, Message: "with fields", Fields: log.Fields{"status": 200}})
+	if want := "INFO: 2014-05-01T12:30:00 (UTC): with fields service=api status=200\n"; buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
+
+// recordWriter keeps the records passed to it
+type recordWriter struct {
+	lineRecorder
+	records []log.LogRecord
+}
+
+func (w *recordWriter) WriteRecord(r log.LogRecord) error {
+	w.mutex.Lock()
+	w.records = append(w.records, r)
+	w.mutex.Unlock()
+	return nil
+}
+
+func TestRecordWriter(t *testing.T) {
+	w := &recordWriter{}
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	logger.SetPrefix("app: ")
+	logger.WithFields(log.Fields{"service": "api"}).Infof("started on port %d", 8080)
+	logger.Debug("filtered")
+
+	if len(w.records) != 1 || len(w.Lines()) != 0 {
+		t.Fatalf("got records %v and lines %q, want one record", w.records, w.Lines())
+	}
+	r := w.records[0]
+	if r.Level != log.LOG_LEVEL_INFO || r.Message != "started on port 8080" || r.Fields["service"] != "api" || r.Time.IsZero() {
+		t.Errorf("record = %+v", r)
+	}
+}
+
+type countingWriter struct {
+	lineRecorder
+	writes int
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	w.writes++
+	w.mutex.Unlock()
+	return w.lineRecorder.Write(data)
+}
+
+func TestBatchingAsyncLogWriter(t *testing.T) {
+	inner := &countingWriter{}
+	w := log.NewBatchingAsyncLogWriter(inner, 100, 5, time.Second)
+	for i := 0; i < 10; i++ {
+		w.Write([]byte(fmt.Sprintf("Message #%d\n", i)))
+	}
+	w.Close()
+
+	if inner.writes != 2 {
+		t.Errorf("%d writes to the underlying writer, want 2 batches", inner.writes)
+	}
+	if n := strings.Count(strings.Join(inner.Lines(), ""), "\n"); n != 10 {
+		t.Errorf("%d messages written, want 10", n)
+	}
+}
+
+func TestBatchingAsyncLogWriterSplit(t *testing.T) {
+	out, errOut := &countingWriter{}, &countingWriter{}
+	w := log.NewBatchingAsyncLogWriter(log.NewSplitWriter(out, errOut, log.LOG_LEVEL_WARN), 100, 10, time.Second)
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	logger.Info("starting")
+	logger.Error("failed")
+	logger.Info("retrying")
+	logger.Info("started")
+	logger.Close()
+
+	if lines := strings.Join(out.Lines(), ""); strings.Count(lines, "INFO: ") != 3 || strings.Contains(lines, "ERROR") {
+		t.Errorf("out got %q, want the three INFO messages", lines)
+	}
+	if lines := strings.Join(errOut.Lines(), ""); strings.Count(lines, "\n") != 1 || !strings.HasPrefix(lines, "ERROR: ") {
+		t.Errorf("errOut got %q, want the ERROR message", lines)
+	}
+	// the two INFO messages after the error still share a write
+	if out.writes != 2 {
+		t.Errorf("%d writes to out, want 2", out.writes)
+	}
+}
+
+func BenchmarkBatchingAsyncLogWriter(b *testing.B) {
+	w := log.NewBatchingAsyncLogWriter(ioutil.Discard, 1000, 100, 0)
+	data := []byte("INFO: 2006-01-02T15:04:05 (UTC): This is a testing message.\n")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Write(data)
+	}
+	w.Close()
+}
+
+func TestFileLoggerDefaultName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	arg0 := os.Args[0]
+	defer func() { os.Args[0] = arg0 }()
+
+	for _, name := range []string{"", "/"} {
+		os.Args[0] = name
+		logger, err := log.NewFileLogger(dir, "", log.LOG_LEVEL_INFO)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Close()
+		if _, err := os.Stat(dir + "/app.log"); err != nil {
+			t.Errorf("os.Args[0] = %q: %v", name, err)
+		}
+		os.Remove(dir + "/app.log")
+	}
+}
+
+func TestFileLoggerPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a trailing separator doesn't end up in the file name
+	logger, err := log.NewFileLogger(dir+"/", "app", log.LOG_LEVEL_INFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Close()
+	if _, err := os.Stat(filepath.Join(dir, "app.log")); err != nil {
+		t.Error(err)
+	}
+
+	// names with separators of any platform are rejected
+	for _, name := range []string{"../etc/passwd", `..\evil`, `logs\app`, "..", "."} {
+		if _, err := log.NewFileLogger(filepath.Join(dir, "sub"), name, log.LOG_LEVEL_INFO); err == nil {
+			t.Errorf("NewFileLogger accepted name %q", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "etc")); !os.IsNotExist(err) {
+		t.Errorf("log file created outside of its directory: %v", err)
+	}
+}
+
+func TestFileLoggerWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_options")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := log.NewFileLoggerWithOptions(log.FileLoggerOptions{
+		Dir:      filepath.Join(dir, "sub"),
+		Name:     "events",
+		Ext:      ".jsonl",
+		FileMode: 0600,
+		DirMode:  0700,
+		Level:    log.LOG_LEVEL_INFO,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello")
+	logger.Close()
+
+	info, err := os.Stat(filepath.Join(dir, "sub", "events.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() == 0 {
+		t.Error("nothing written to the log file")
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// the umask can only take permissions away
+	if mode := info.Mode().Perm(); mode&^0600 != 0 {
+		t.Errorf("file mode = %v, want at most 0600", mode)
+	}
+	dirInfo, err := os.Stat(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := dirInfo.Mode().Perm(); mode&^0700 != 0 {
+		t.Errorf("directory mode = %v, want at most 0700", mode)
+	}
+}
+
+func TestStack(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	logger.Stack(log.LOG_LEVEL_WARN, "how did we get here")
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "WARN: ") || !strings.Contains(out, "how did we get here") {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if !strings.Contains(out, "TestStack") {
+		t.Errorf("stack doesn't contain the calling test: %q", out)
+	}
+
+	buf.Reset()
+	logger.SetStackDepth(1)
+	logger.Stack(log.LOG_LEVEL_WARN, "one frame")
+	if n := strings.Count(buf.String(), "\n\t\t"); n != 1 {
+		t.Errorf("logged %d frames with depth 1", n)
+	}
+}
+
+func TestHTTPLogWriterFailover(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	received := make(chan string, 1)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received <- string(data)
+	}))
+	defer secondary.Close()
+
+	w := log.NewFailoverHTTPLogWriter([]string{primary.URL, secondary.URL}, false)
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	logger.Info("failing over")
+
+	select {
+	case msg := <-received:
+		if !strings.Contains(msg, "failing over") {
+			t.Errorf("secondary received %q", msg)
+		}
+	default:
+		t.Error("message didn't reach the secondary server")
+	}
+}
+
+// minLevelWriter only accepts messages at or above WARN
+type minLevelWriter struct {
+	bytes.Buffer
+}
+
+func (w *minLevelWriter) MinLevel() int {
+	return log.LOG_LEVEL_WARN
+}
+
+// countingFormatter counts how many messages it formats
+type countingFormatter struct {
+	log.DefaultLogFormatter
+	count int
+}
+
+func (f *countingFormatter) Format(t time.Time, level int, message string) string {
+	f.count++
+	return f.DefaultLogFormatter.Format(t, level, message)
+}
+
+func TestMinLevelWriter(t *testing.T) {
+	w := &minLevelWriter{}
+	formatter := &countingFormatter{}
+	logger := log.New(w, log.LOG_LEVEL_DEBUG)
+	logger.SetFormatter(formatter)
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	if formatter.count != 2 {
+		t.Errorf("formatted %d messages, want only the 2 the writer accepts", formatter.count)
+	}
+	if strings.Contains(w.String(), "info") || !strings.Contains(w.String(), "warn") {
+		t.Errorf("unexpected output: %q", w.String())
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_WARN)
+	logger.EnableLifecycleEvents()
+	logger.Warn("one")
+	logger.Error("two")
+	logger.Info("filtered")
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "logger_started level=WARN formatter=*log.DefaultLogFormatter writer=*bytes.Buffer") {
+		t.Errorf("unexpected start event: %q", lines[0])
+	}
+	if !strings.Contains(lines[3], "logger_closed messages_written=2 messages_dropped=0") {
+		t.Errorf("unexpected close event: %q", lines[3])
+	}
+}
+
+func TestDynamicFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+
+	request := 0
+	logger.SetDynamicFields(func() map[string]interface{} {
+		request++
+		return map[string]interface{}{"request": request, "tenant": "acme"}
+	})
+	logger.Info("first")
+	logger.Infoln("second")
+	logger.Debug("filtered")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "first request=1 tenant=acme") {
+		t.Errorf("unexpected first line: %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "second request=2 tenant=acme") {
+		t.Errorf("unexpected second line: %q", lines[1])
+	}
+	if request != 2 {
+		t.Errorf("provider called %d times, want once per written message", request)
+	}
+}
+
+func TestLogLevelRoundTrip(t *testing.T) {
+	for _, level := range []int{log.LOG_LEVEL_TRACE, log.LOG_LEVEL_DEBUG, log.LOG_LEVEL_INFO,
+		log.LOG_LEVEL_WARN, log.LOG_LEVEL_ERROR, log.LOG_LEVEL_FATAL} {
+		if got := log.String2LogLevel(log.LogLevel2String(level)); got != level {
+			t.Errorf("String2LogLevel(LogLevel2String(%d)) = %d", level, got)
+		}
+	}
+	if got := log.String2LogLevel("warning"); got != log.LOG_LEVEL_WARN {
+		t.Errorf("String2LogLevel(\"warning\") = %d, want LOG_LEVEL_WARN", got)
+	}
+	if got := log.String2LogLevel("verbose"); got != -1 {
+		t.Errorf("String2LogLevel(\"verbose\") = %d, want -1", got)
+	}
+}
+
+func TestLevelOff(t *testing.T) {
+	for _, name := range []string{"off", "SILENT"} {
+		if got := log.String2LogLevel(name); got != log.LOG_LEVEL_OFF {
+			t.Errorf("String2LogLevel(%q) = %d, want LOG_LEVEL_OFF", name, got)
+		}
+	}
+	if got := log.LogLevel2String(log.LOG_LEVEL_OFF); got != "OFF" {
+		t.Errorf("LogLevel2String(LOG_LEVEL_OFF) = %q, want OFF", got)
+	}
+
+	buf := &bytes.Buffer{}
+	logger := log.New(buf, log.LOG_LEVEL_OFF)
+	formatter := &countingFormatter{}
+	logger.SetFormatter(formatter)
+	logger.Log(log.LOG_LEVEL_FATAL, "fatal")
+	logger.Logf(log.LOG_LEVEL_FATAL, "fatal %d", 1)
+	logger.Logln(log.LOG_LEVEL_FATAL, "fatal")
+	logger.Log(log.LOG_LEVEL_OFF, "off")
+	if buf.Len() != 0 || formatter.count != 0 {
+		t.Errorf("logger at LOG_LEVEL_OFF formatted %d messages: %q", formatter.count, buf.String())
+	}
+}
+
+// The registry is global, so the levels are registered once however often the tests run
+var registerLevels sync.Once
+
+func TestRegisterLevel(t *testing.T) {
+	const notice = log.LOG_LEVEL_INFO + 5
+	const chatter = log.LOG_LEVEL_TRACE - 5
+	var errs [2]error
+	registerLevels.Do(func() {
+		errs[0] = log.RegisterLevel(notice, "NOTICE")
+		errs[1] = log.RegisterLevel(chatter, "CHATTER")
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := log.LogLevel2String(notice); got != "NOTICE" {
+		t.Errorf("LogLevel2String(notice) = %q, want NOTICE", got)
+	}
+	if got := log.String2LogLevel("notice"); got != notice {
+		t.Errorf("String2LogLevel(\"notice\") = %d, want %d", got, notice)
+	}
+	if got := log.String2LogLevel("chatter"); got != chatter {
+		t.Errorf("String2LogLevel(\"chatter\") = %d, want %d", got, chatter)
+	}
+	if err := log.RegisterLevel(notice, "ANNOUNCE"); err == nil {
+		t.Error("registering a taken value succeeded")
+	}
+	if err := log.RegisterLevel(notice+1, "warn"); err == nil {
+		t.Error("registering a built-in name succeeded")
+	}
+	if err := log.RegisterLevel(log.LOG_LEVEL_DEBUG, "FINE"); err == nil {
+		t.Error("registering a built-in value succeeded")
+	}
+
+	buf := &lineRecorder{}
+	logger := log.New(buf, notice)
+	logger.Log(log.LOG_LEVEL_INFO, "starting")
+	logger.Log(notice, "deploy finished")
+	logger.Log(log.LOG_LEVEL_WARN, "disk almost full")
+	lines := buf.Lines()
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "NOTICE: ") || !strings.HasPrefix(lines[1], "WARN: ") {
+		t.Errorf("logger at NOTICE wrote %q, want the NOTICE and WARN messages", lines)
+	}
+
+	buf = &lineRecorder{}
+	logger = log.New(buf, log.LOG_LEVEL_INFO)
+	logger.Log(chatter, "polling")
+	logger.Log(notice, "deploy finished")
+	if lines := buf.Lines(); len(lines) != 1 || !strings.HasPrefix(lines[0], "NOTICE: ") {
+		t.Errorf("logger at INFO wrote %q, want the NOTICE message", lines)
+	}
+}
+
+func TestRegisterLevelConcurrent(t *testing.T) {
+	const value = log.LOG_LEVEL_ERROR + 5
+	name := fmt.Sprintf("RACE%d", time.Now().UnixNano())
+	var wg sync.WaitGroup
+	var ok int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if log.RegisterLevel(value+i%2, name) == nil {
+				atomic.AddInt32(&ok, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ok > 1 {
+		t.Errorf("%d concurrent registrations of %s succeeded, want at most one", ok, name)
+	}
+}
+
+type countingSyncer struct {
+	bytes.Buffer
+	syncs int32
+}
+
+func (s *countingSyncer) Sync() error {
+	atomic.AddInt32(&s.syncs, 1)
+	return nil
+}
+
+func TestSyncInterval(t *testing.T) {
+	w := &countingSyncer{}
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	logger.SetSyncInterval(10 * time.Millisecond)
+
+	// nothing is written, yet the writer is synced on schedule
+	time.Sleep(100 * time.Millisecond)
+	logger.Close()
+	time.Sleep(20 * time.Millisecond) // let a sync that was in progress finish
+	synced := atomic.LoadInt32(&w.syncs)
+	if synced < 3 {
+		t.Errorf("synced %d times in 100ms with a 10ms interval", synced)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&w.syncs); n != synced {
+		t.Errorf("still syncing after Close: %d syncs, was %d", n, synced)
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := os.Create(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(log.NewAsyncLogWriter(file, 10), log.LOG_LEVEL_INFO)
+	defer logger.Close()
+	logger.Info("user deleted")
+	if err := logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queued message is in the file, not just in the writer
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "user deleted") {
+		t.Errorf("message not in the file after Sync: %q", data)
+	}
+}
+
+func TestSyncLevel(t *testing.T) {
+	w := &countingSyncer{}
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	logger.SetSyncLevel(log.LOG_LEVEL_ERROR)
+	logger.Info("not synced")
+	logger.Warn("not synced")
+	logger.WithFields(log.Fields{"id": 1}).Error("synced")
+	logger.Log(log.LOG_LEVEL_FATAL, "synced")
+	if n := atomic.LoadInt32(&w.syncs); n != 2 {
+		t.Errorf("synced %d times, want once per ERROR or FATAL message", n)
+	}
+
+	logger.SetSyncLevel(0)
+	logger.Error("not synced")
+	if n := atomic.LoadInt32(&w.syncs); n != 2 {
+		t.Errorf("synced %d times after SetSyncLevel(0), want 2", n)
+	}
+}
+
+func TestPrintLogsAtInfo(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_WARN)
+	logger.Print("print")
+	logger.Println("println")
+	logger.Printf("printf %d", 1)
+	if buf.Len() != 0 {
+		t.Errorf("Print wrote %q below the WARN threshold", buf.String())
+	}
+
+	logger.SetLogLevel(log.LOG_LEVEL_DEBUG)
+	logger.Print("print")
+	if !strings.HasPrefix(buf.String(), "INFO: ") {
+		t.Errorf("Print wrote %q, want an INFO line", buf.String())
+	}
+}
+
+func TestLnSingleNewline(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	logger.Infoln("hi")
+	if out := buf.String(); !strings.HasSuffix(out, ": hi\n") || strings.HasSuffix(out, "\n\n") {
+		t.Errorf("Infoln wrote %q, want exactly one trailing newline", out)
+	}
+
+	buf.Reset()
+	logger.WithFields(log.Fields{"k": "v"}).Println("a", 1)
+	if out := buf.String(); !strings.HasSuffix(out, ": a 1 k=v\n") {
+		t.Errorf("Println wrote %q, want the fields on the same line", out)
+	}
+}
+
+func TestHasWriter(t *testing.T) {
+	var a, b bytes.Buffer
+	logger := log.New(&a, log.LOG_LEVEL_INFO)
+	if !logger.HasWriter(&a) || logger.HasWriter(&b) {
+		t.Error("HasWriter doesn't match the configured writer")
+	}
+
+	logger.SetWriterWithClose(&b, nil)
+	if logger.HasWriter(&a) || !logger.HasWriter(&b) {
+		t.Error("HasWriter doesn't follow the writer change")
+	}
+}
+
+func TestCRLFLineEnding(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	logger.SetFormatter(&log.DefaultLogFormatter{LineEnding: log.CRLF})
+	logger.Info("first")
+	logger.Info("second")
+
+	if strings.Count(buf.String(), "\r\n") != 2 || !strings.HasSuffix(buf.String(), "second\r\n") {
+		t.Errorf("expected CRLF line endings: %q", buf.String())
+	}
+}
+
+var _ log.SyncWriteCloser = (*log.AsyncLogWriter)(nil)
+
+func TestErrorfWrapVerb(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+
+	// vet treats the logger as a Sprintf wrapper and rejects a constant %w format
+	wrap, retry := "startup failed: %w", "retry %d: %w"
+	err := fmt.Errorf("open config.yml: %w", os.ErrNotExist)
+	logger.Errorf(wrap, err)
+	if !strings.HasSuffix(buf.String(), ": startup failed: open config.yml: file does not exist\n") {
+		t.Errorf("logged %q, want the wrapped error rendered", buf.String())
+	}
+	if msg := logger.Errorfs(retry, 2, err); msg != "retry 2: open config.yml: file does not exist" {
+		t.Errorf("Errorfs returned %q", msg)
+	}
+}
+
+func TestDefaultLogFormatterLayout(t *testing.T) {
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+
+	if got := (&log.DefaultLogFormatter{}).Format(ts, log.LOG_LEVEL_INFO, "msg"); got != "INFO: 2014-05-01T10:30:00 (UTC): msg\n" {
+		t.Errorf("zero value formatted %q", got)
+	}
+	if got := log.NewDefaultLogFormatter(time.RFC3339, true).Format(ts, log.LOG_LEVEL_INFO, "msg"); got != "INFO: 2014-05-01T10:30:00Z: msg\n" {
+		t.Errorf("RFC3339 in UTC formatted %q", got)
+	}
+	local := ts.Local().Format("15:04")
+	if got := log.NewDefaultLogFormatter("15:04", false).Format(ts, log.LOG_LEVEL_INFO, "msg"); got != "INFO: "+local+": msg\n" {
+		t.Errorf("local time formatted %q, want %q", got, local)
+	}
+}
+
+func TestReportCaller(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	logger.SetReportCaller(true)
+
+	var want []string
+	// calledAbove records the line before its call site as the expected caller
+	calledAbove := func() {
+		_, file, line, _ := runtime.Caller(1)
+		want = append(want, fmt.Sprintf("(%s:%d)", filepath.Base(file), line-1))
+	}
+	logger.Info("info")
+	calledAbove()
+	logger.Infof("infof %d", 1)
+	calledAbove()
+	logger.Infoln("infoln")
+	calledAbove()
+	logger.Printf("printf")
+	calledAbove()
+	logger.Log(log.LOG_LEVEL_WARN, "log")
+	calledAbove()
+	logger.Warnfs("warnfs")
+	calledAbove()
+	logger.WithFields(log.Fields{"k": "v"}).Error("fields")
+	calledAbove()
+
+	lines := rec.Lines()
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(strings.TrimRight(line, "\n"), " "+want[i]) {
+			t.Errorf("line %q doesn't end with the call site %s", line, want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	logger = log.New(&buf, log.LOG_LEVEL_INFO)
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.LogRecord(log.LogRecord{Level: log.LOG_LEVEL_INFO, Message: "bridged", Caller: "adapter.go:7"})
+	if !strings.Contains(buf.String(), `"message":"bridged","caller":"adapter.go:7"`) {
+		t.Errorf("JSON formatter rendered %q", buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(data []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestErrorHandler(t *testing.T) {
+	var errs []error
+	logger := log.New(failingWriter{}, log.LOG_LEVEL_INFO)
+	logger.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	logger.Info("lost")
+	logger.WithFields(log.Fields{"k": "v"}).Info("lost too")
+	if len(errs) != 2 || errs[0].Error() != "disk full" {
+		t.Errorf("handler got %v, want 2 errors", errs)
+	}
+
+	// failures in the background goroutine reach the same handler
+	asyncErrs := make(chan error, 1)
+	logger = log.New(log.NewAsyncLogWriter(failingWriter{}, 10), log.LOG_LEVEL_INFO)
+	logger.SetErrorHandler(func(err error) { asyncErrs <- err })
+	logger.Info("lost in the background")
+	select {
+	case err := <-asyncErrs:
+		if err.Error() != "disk full" {
+			t.Errorf("async handler got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("async write error wasn't reported")
+	}
+	logger.Close()
+}
+
+func TestAsyncLogWriterPolicy(t *testing.T) {
+	for _, policy := range []log.OverflowPolicy{log.BlockOnFull, log.DropOnFull, log.DropOldestOnFull} {
+		inner := &gatedWriter{gate: make(chan int)}
+		w := log.NewAsyncLogWriterWithPolicy(inner, 2, policy)
+
+		// the inner writer is stuck, so at most three messages fit: one being written and two queued
+		done := make(chan int)
+		go func() {
+			for i := 0; i < 10; i++ {
+				w.Write([]byte(fmt.Sprintf("Message #%d\n", i)))
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+			if policy == log.BlockOnFull {
+				t.Errorf("policy %d: writes didn't block on a full queue", policy)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if policy != log.BlockOnFull {
+				t.Errorf("policy %d: writes blocked on a full queue", policy)
+			}
+		}
+		close(inner.gate)
+		<-done
+		w.Close()
+
+		lines := inner.Lines()
+		if n := uint64(len(lines)) + w.DroppedCount(); n != 10 {
+			t.Errorf("policy %d: %d written + %d dropped messages, want 10", policy, len(lines), w.DroppedCount())
+		}
+		last := lines[len(lines)-1]
+		switch policy {
+		case log.DropOnFull:
+			if lines[0] != "Message #0\n" || last == "Message #9\n" {
+				t.Errorf("DropOnFull wrote %q", lines)
+			}
+		case log.DropOldestOnFull, log.BlockOnFull:
+			if last != "Message #9\n" {
+				t.Errorf("policy %d wrote %q", policy, lines)
+			}
+		}
+	}
+}
+
+func TestLoggerFlush(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(log.NewAsyncLogWriter(rec, 10), log.LOG_LEVEL_INFO)
+	defer logger.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				logger.Infof("goroutine %d message %d", g, i)
+				if i%10 == 0 {
+					logger.Flush()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if _, err := logger.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rec.Lines()); n != 200 {
+		t.Errorf("%d messages written after Flush, want 200", n)
+	}
+	logger.Info("still running")
+	logger.Flush()
+	if n := len(rec.Lines()); n != 201 {
+		t.Errorf("%d messages written after the second Flush, want 201", n)
+	}
+}
+
+func TestClone(t *testing.T) {
+	w := &closeRecorder{}
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	clone := logger.Clone()
+	clone.SetLogLevel(log.LOG_LEVEL_DEBUG)
+	clone.SetFormatter(&log.JSONFormatter{})
+
+	if logger.GetLevel() != log.LOG_LEVEL_INFO || clone.GetLevel() != log.LOG_LEVEL_DEBUG {
+		t.Errorf("levels are %d and %d, want the clone's level changed only", logger.GetLevel(), clone.GetLevel())
+	}
+	logger.Debug("filtered")
+	clone.Debug("debug")
+	logger.Info("info")
+	lines := strings.SplitAfter(strings.TrimSpace(w.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "{") || !strings.HasPrefix(lines[1], "INFO: ") {
+		t.Errorf("shared writer received %q", lines)
+	}
+
+	clone.Close()
+	if w.closed {
+		t.Error("closing the clone closed the writer")
+	}
+	logger.Close()
+	if !w.closed {
+		t.Error("closing the logger didn't close the writer")
+	}
+}
+
+func TestDup(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.LOG_LEVEL_INFO)
+	dup := logger.Dup()
+	dup.SetFormatter(&upperFormatter{})
+	dup.SetLogLevel(log.LOG_LEVEL_ERROR)
+
+	logger.Info("original")
+	dup.Info("filtered by the dup")
+	dup.Error("dup")
+	lines := strings.SplitAfter(buf.String(), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], ": original\n") || lines[1] != "DUP\n" {
+		t.Errorf("shared writer received %q", buf.String())
+	}
+	if c := logger.Config(); c.Formatter != "*log.DefaultLogFormatter" || c.Level != log.LOG_LEVEL_INFO {
+		t.Errorf("original changed with the dup: %+v", c)
+	}
+
+	// closing the dup leaves the writer to the original
+	w := &closeRecorder{}
+	log.New(w, log.LOG_LEVEL_INFO).Dup().Close()
+	if w.closed {
+		t.Error("closing the dup closed the writer")
+	}
+
+	// the original and the dup write concurrently, through an AsyncLogWriter for a bytes.Buffer
+	buf.Reset()
+	async := log.NewAsyncLogWriter(&buf, log.DEFAULT_QUEUE_SIZE)
+	logger = log.New(async, log.LOG_LEVEL_INFO)
+	var wg sync.WaitGroup
+	for _, l := range []*log.Logger{logger, logger.Dup()} {
+		wg.Add(1)
+		go func(l *log.Logger) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				l.Info("concurrent")
+			}
+		}(l)
+	}
+	wg.Wait()
+	logger.Close()
+	if n := strings.Count(buf.String(), "\n"); n != 100 {
+		t.Errorf("%d lines from the original and the dup, want 100", n)
+	}
+}
+
+func TestBatchingHTTPLogWriter(t *testing.T) {
+	var requests, lines int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		atomic.AddInt32(&requests, 1)
+		atomic.AddInt32(&lines, int32(strings.Count(string(data), "\n")))
+	}))
+	defer server.Close()
+
+	logger := log.New(log.NewBatchingHTTPLogWriter(server.URL, 25, 100*time.Millisecond), log.LOG_LEVEL_INFO)
+	for i := 0; i < 90; i++ {
+		logger.Infof("Message #%d", i)
+	}
+	logger.Close()
+
+	// 90 lines fit in 4 requests, Close sends the last partial batch
+	if n := atomic.LoadInt32(&lines); n != 90 {
+		t.Errorf("server received %d lines, want 90", n)
+	}
+	if n := atomic.LoadInt32(&requests); n < 4 || n > 6 {
+		t.Errorf("server received %d requests for 90 lines in batches of 25", n)
+	}
+}
+
+func TestHTTPLogWriterLevel(t *testing.T) {
+	type request struct{ level, body string }
+	received := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- request{r.Header.Get(log.LEVEL_HEADER), string(body)}
+	}))
+	defer server.Close()
+
+	logger := log.New(log.NewFailoverHTTPLogWriter([]string{server.URL}, false), log.LOG_LEVEL_INFO)
+	logger.Warn("disk almost full")
+	if r := <-received; r.level != "WARN" || !strings.HasSuffix(r.body, ": disk almost full\n") {
+		t.Errorf("server received level %q with %q", r.level, r.body)
+	}
+
+	// the level passes through the queue, a batch only combines messages of one level
+	logger = log.New(log.NewBatchingHTTPLogWriter(server.URL, 3, time.Second), log.LOG_LEVEL_INFO)
+	logger.Info("starting")
+	logger.Info("started")
+	logger.Error("failed")
+	logger.Close()
+	if r := <-received; r.level != "INFO" || strings.Count(r.body, "\n") != 2 {
+		t.Errorf("server received level %q with %q", r.level, r.body)
+	}
+	if r := <-received; r.level != "ERROR" || strings.Count(r.body, "\n") != 1 {
+		t.Errorf("server received level %q with %q", r.level, r.body)
+	}
+
+	// plain writes don't claim a level
+	log.NewFailoverHTTPLogWriter([]string{server.URL}, false).Write([]byte("raw\n"))
+	if r := <-received; r.level != "" {
+		t.Errorf("Write sent level %q", r.level)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	inner := &gatedWriter{gate: make(chan int)}
+	logger := log.New(inner, log.LOG_LEVEL_INFO)
+	logger.SetWriteTimeout(50 * time.Millisecond)
+	var errs []error
+	logger.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	start := time.Now()
+	logger.Info("stuck")
+	logger.Info("failed right away while the first write is pending")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("log calls took %v with a 50ms write timeout", elapsed)
+	}
+	if len(errs) != 2 || errs[0] != log.ErrWriteTimeout || errs[1] != log.ErrWriteTimeout {
+		t.Errorf("errors = %v, want 2 timeouts", errs)
+	}
+
+	// once the writer recovers, writes go through again
+	close(inner.gate)
+	time.Sleep(20 * time.Millisecond)
+	logger.Info("recovered")
+	if lines := inner.Lines(); len(lines) != 2 || len(errs) != 2 {
+		t.Errorf("wrote %q with errors %v", lines, errs)
+	}
+}
+
+// gatedRecordWriter formats the records it gets, and writes them once its gate is open
+type gatedRecordWriter struct {
+	gatedWriter
+}
+
+func (w *gatedRecordWriter) WriteRecord(r log.LogRecord) error {
+	line := fmt.Sprintln(r.Message, r.Fields)
+	<-w.gate
+	_, err := w.lineRecorder.Write([]byte(line))
+	return err
+}
+
+func TestWriteTimeoutOutlivesCall(t *testing.T) {
+	inner := &gatedRecordWriter{gatedWriter{gate: make(chan int)}}
+	logger := log.New(inner, log.LOG_LEVEL_INFO)
+	logger.SetWriteTimeout(10 * time.Millisecond)
+	errs := make(chan error, 10)
+	logger.SetErrorHandler(func(err error) { errs <- err })
+
+	// the caller reuses the fields and logs again while the timed out write is still running, which the
+	// race detector reports unless the write has its own copy
+	fields := log.Fields{"n": 1}
+	logger.LogRecord(log.LogRecord{Time: time.Now(), Level: log.LOG_LEVEL_INFO, Message: "stuck", Fields: fields})
+	fields["n"] = 2
+	logger.LogRecord(log.LogRecord{Time: time.Now(), Level: log.LOG_LEVEL_INFO, Message: "again", Fields: fields})
+	if err := <-errs; err != log.ErrWriteTimeout {
+		t.Fatalf("first message failed with %v, want a timeout", err)
+	}
+
+	close(inner.gate)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(inner.Lines()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if lines := inner.Lines(); len(lines) != 1 || lines[0] != "stuck map[n:1]\n" {
+		t.Errorf("wrote %q, want the first message with its fields as they were at the call", lines)
+	}
+}
+
+func TestHTTPLogWriterRetry(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	w := log.NewFailoverHTTPLogWriter([]string{server.URL}, false)
+	w.SetRetry(3, 10*time.Millisecond)
+	if _, err := w.Write([]byte("retried\n")); err != nil || atomic.LoadInt32(&attempts) != 3 {
+		t.Errorf("Write returned %v after %d attempts, want success on the third", err, attempts)
+	}
+
+	// client errors are not retried
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	atomic.StoreInt32(&attempts, 0)
+	w = log.NewFailoverHTTPLogWriter([]string{rejecting.URL}, false)
+	w.SetRetry(3, 10*time.Millisecond)
+	if _, err := w.Write([]byte("rejected\n")); err == nil || atomic.LoadInt32(&attempts) != 1 {
+		t.Errorf("Write returned %v after %d attempts, want a single failed attempt", err, attempts)
+	}
+}
+
+func TestHTTPLogWriterStatus(t *testing.T) {
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	w := log.NewFailoverHTTPLogWriter([]string{server.URL}, false)
+	if _, err := w.Write([]byte("accepted\n")); err != nil {
+		t.Errorf("204 response returned %v", err)
+	}
+	status = http.StatusInternalServerError
+	if _, err := w.Write([]byte("failed\n")); err == nil || err.Error() != "HTTPLogWriter: 500 error!" {
+		t.Errorf("500 response returned %v", err)
+	}
+}
+
+type plainCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *plainCloser) Close() {
+	w.closed = true
+}
+
+type shutdownWriter struct {
+	bytes.Buffer
+	shutdown bool
+}
+
+func (w *shutdownWriter) Shutdown(ctx context.Context) error {
+	w.shutdown = true
+	return nil
+}
+
+func TestCloseVariants(t *testing.T) {
+	plain := &plainCloser{}
+	log.New(plain, log.LOG_LEVEL_INFO).Close()
+	if !plain.closed {
+		t.Error("Close() without error result wasn't called")
+	}
+
+	sw := &shutdownWriter{}
+	log.New(sw, log.LOG_LEVEL_INFO).Close()
+	if !sw.shutdown {
+		t.Error("Shutdown wasn't called")
+	}
+
+	// writers wrapped by the writers of this package are closed the same way
+	plain = &plainCloser{}
+	log.New(log.NewAsyncLogWriter(plain, 10), log.LOG_LEVEL_INFO).Close()
+	if !plain.closed {
+		t.Error("AsyncLogWriter didn't close its writer")
+	}
+}
+
+func TestIsLevelEnabled(t *testing.T) {
+	logger := log.New(&minLevelWriter{}, log.LOG_LEVEL_INFO)
+	if logger.GetLevel() != log.LOG_LEVEL_INFO {
+		t.Errorf("GetLevel = %d", logger.GetLevel())
+	}
+	if logger.IsLevelEnabled(log.LOG_LEVEL_DEBUG) || logger.IsLevelEnabled(log.LOG_LEVEL_INFO) || !logger.IsLevelEnabled(log.LOG_LEVEL_WARN) {
+		t.Error("IsLevelEnabled ignores the logger or writer level")
+	}
+
+	// reading the level while another goroutine changes it is safe
+	done := make(chan int)
+	go func() {
+		for i := 0; i < 100; i++ {
+			logger.SetLogLevel(log.LOG_LEVEL_TRACE + i%5)
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		logger.IsLevelEnabled(log.LOG_LEVEL_DEBUG)
+		logger.GetLevel()
+	}
+	<-done
+}
+
+func TestFlushCount(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(log.NewAsyncLogWriter(rec, 10), log.LOG_LEVEL_INFO)
+	defer logger.Close()
+
+	for i := 0; i < 7; i++ {
+		logger.Infof("Message #%d", i)
+	}
+	if n, err := logger.Flush(); n != 7 || err != nil {
+		t.Errorf("Flush returned %d, %v, want 7 messages", n, err)
+	}
+	if n, _ := logger.Flush(); n != 0 {
+		t.Errorf("second Flush returned %d, want 0", n)
+	}
+
+	// batched messages are counted one by one
+	rec = &lineRecorder{}
+	w := log.NewBatchingAsyncLogWriter(rec, 10, 4, time.Millisecond)
+	for i := 0; i < 9; i++ {
+		fmt.Fprintf(w, "Message #%d\n", i)
+	}
+	if n, err := w.FlushN(); n != 9 || err != nil {
+		t.Errorf("FlushN returned %d, %v, want 9 messages", n, err)
+	}
+	w.Close()
+}
+
+// reentrantFormatter logs to its own logger while formatting
+type reentrantFormatter struct {
+	log.DefaultLogFormatter
+	logger *log.Logger
+}
+
+func (f *reentrantFormatter) Format(t time.Time, level int, message string) string {
+	f.logger.Warn("formatting ", message)
+	return f.DefaultLogFormatter.Format(t, level, message)
+}
+
+func TestReentrantLogging(t *testing.T) {
+	done := make(chan int)
+	rec := &lineRecorder{}
+	go func() {
+		defer close(done)
+		logger := log.New(rec, log.LOG_LEVEL_INFO)
+		logger.SetFormatter(&reentrantFormatter{logger: logger.WithFields(log.Fields{"child": true})})
+		logger.Info("from the formatter")
+
+		// an error handler logging to the failing logger doesn't recurse
+		failing := log.New(failingWriter{}, log.LOG_LEVEL_INFO)
+		failing.SetErrorHandler(func(err error) { failing.Error("write failed: ", err) })
+		failing.Info("from the error handler")
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reentrant logging deadlocked")
+	}
+	if lines := rec.Lines(); len(lines) != 1 || !strings.HasSuffix(lines[0], ": from the formatter\n") {
+		t.Errorf("wrote %q, want only the outer message", lines)
+	}
+
+	// other goroutines log normally while one is emitting
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info("concurrent")
+		}()
+	}
+	wg.Wait()
+	if n := len(rec.Lines()); n != 5 {
+		t.Errorf("%d lines written, want 5", n)
+	}
+}
+
+// slowFormatter takes a while to format every message
+type slowFormatter struct {
+	log.DefaultLogFormatter
+}
+
+func (f *slowFormatter) FormatTo(buf *bytes.Buffer, t time.Time, level int, message string, fields log.Fields) {
+	time.Sleep(15 * time.Millisecond)
+	buf.WriteString(f.Format(t, level, message))
+}
+
+func TestSlowFormatterConcurrent(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	logger.SetFormatter(&slowFormatter{})
+
+	// goroutines wait for each other's formatting far longer than a reentrant wait, and lose nothing
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				logger.Infof("goroutine %d message %d", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(rec.Lines()); n != 20 {
+		t.Errorf("%d lines written, want 20", n)
+	}
+}
+
+// loggingHook logs to its own logger when it fires
+type loggingHook struct {
+	logger *log.Logger
+	fired  int
+}
+
+func (h *loggingHook) Levels() []int {
+	return []int{log.LOG_LEVEL_ERROR}
+}
+
+func (h *loggingHook) Fire(t time.Time, level int, message string) error {
+	h.fired++
+	h.logger.Error("hook saw: ", message)
+	return nil
+}
+
+func TestReentrantHook(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	hook := &loggingHook{logger: logger}
+	logger.AddHook(hook)
+	logger.Error("disk full")
+
+	// the message of the hook is written, but doesn't fire the hook again
+	lines := rec.Lines()
+	if hook.fired != 1 || len(lines) != 2 || !strings.HasSuffix(lines[1], ": hook saw: disk full\n") {
+		t.Errorf("hook fired %d times and wrote %q, want once with its own message", hook.fired, lines)
+	}
+
+	// a hook logging to another logger fires that logger's hooks
+	other := log.New(ioutil.Discard, log.LOG_LEVEL_INFO)
+	counter := &countingHook{fired: map[int]int{}}
+	other.AddHook(counter)
+	logger = log.New(ioutil.Discard, log.LOG_LEVEL_INFO)
+	logger.AddHook(&loggingHook{logger: other})
+	logger.Error("disk full")
+	if counter.fired[log.LOG_LEVEL_ERROR] != 1 {
+		t.Errorf("hook of the other logger fired %d times, want once", counter.fired[log.LOG_LEVEL_ERROR])
+	}
+}
+
+func TestSetLogLevelWhileLogging(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	child := logger.WithFields(log.Fields{"child": true})
+
+	stop := make(chan int)
+	flipped := make(chan int)
+	go func() {
+		defer close(flipped)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			logger.SetLogLevel(log.LOG_LEVEL_DEBUG + i%2*2)
+			child.SetLogLevel(log.LOG_LEVEL_DEBUG + i%2*2)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				logger.Debug("debug")
+				child.Warn("warn")
+				logger.IsLevelEnabled(log.LOG_LEVEL_INFO)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-flipped
+
+	warnings := 0
+	for _, line := range rec.Lines() {
+		if strings.Contains(line, ": warn") {
+			warnings++
+		}
+	}
+	if warnings != 800 {
+		t.Errorf("logged %d warnings, want 800", warnings)
+	}
+}
+
+func TestLevelWriter(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	std := stdlog.New(logger.LevelWriter(log.LOG_LEVEL_WARN), "", 0)
+	std.Println("from the standard logger")
+	stdlog.New(logger.LevelWriter(log.LOG_LEVEL_DEBUG), "", 0).Print("filtered")
+
+	lines := rec.Lines()
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "WARN: ") || !strings.HasSuffix(lines[0], ": from the standard logger\n") {
+		t.Errorf("standard logger wrote %q, want a single WARN line", lines)
+	}
+}
+
+func TestAsyncLogWriterOrder(t *testing.T) {
+	rec := &lineRecorder{}
+	w := log.NewAsyncLogWriter(rec, 8)
+
+	// the writes are serialized by the mutex, so every Write returns before the next one starts
+	var mutex sync.Mutex
+	seq := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(producer int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				mutex.Lock()
+				fmt.Fprintf(w, "%d %d %d\n", seq, producer, j)
+				seq++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	w.Close()
+
+	lines := rec.Lines()
+	if len(lines) != 800 {
+		t.Fatalf("wrote %d lines, want 800", len(lines))
+	}
+	next := make([]int, 8)
+	for i, line := range lines {
+		var n, producer, j int
+		fmt.Sscanf(line, "%d %d %d", &n, &producer, &j)
+		if n != i || j != next[producer] {
+			t.Fatalf("line %d is %q, messages are out of order", i, line)
+		}
+		next[producer]++
+	}
+}
+
+func TestSetWriter(t *testing.T) {
+	a, b, c := &closeRecorder{}, &closeRecorder{}, &closeRecorder{}
+	logger := log.New(a, log.LOG_LEVEL_INFO)
+	logger.Info("to a")
+	logger.SetWriter(b)
+	logger.Info("to b")
+	if !strings.HasSuffix(a.String(), ": to a\n") || strings.Count(a.String(), "\n") != 1 {
+		t.Errorf("a received %q", a.String())
+	}
+	if !strings.HasSuffix(b.String(), ": to b\n") || strings.Count(b.String(), "\n") != 1 {
+		t.Errorf("b received %q", b.String())
+	}
+	if !a.closed || b.closed {
+		t.Error("SetWriter didn't close only the previous writer")
+	}
+
+	// SwapWriter hands the previous writer back open
+	if old := logger.SwapWriter(c); old != io.Writer(b) || b.closed {
+		t.Errorf("SwapWriter returned %v and closed it: %v", old, b.closed)
+	}
+	logger.Close()
+	if !c.closed {
+		t.Error("Close didn't close the swapped in writer")
+	}
+}
+
+func TestSetOutputAndPrefix(t *testing.T) {
+	var a, b bytes.Buffer
+	logger := log.New(&a, log.LOG_LEVEL_INFO)
+	logger.SetPrefix("[api] ")
+	logger.Info("to a")
+	logger.SetOutput(&b)
+	logger.Debug("filtered")
+	logger.WithFields(log.Fields{"k": "v"}).Warn("to b")
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.Error("json")
+
+	if !strings.HasPrefix(a.String(), "[api] INFO: ") || strings.Count(a.String(), "\n") != 1 {
+		t.Errorf("a received %q", a.String())
+	}
+	lines := strings.SplitAfter(b.String(), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "[api] WARN: ") || !strings.HasSuffix(lines[0], ": to b k=v\n") {
+		t.Fatalf("b received %q", b.String())
+	}
+	if !strings.HasPrefix(lines[1], `[api] {"`) {
+		t.Errorf("JSON line %q doesn't start with the prefix", lines[1])
+	}
+}
+
+func TestNullLogger(t *testing.T) {
+	logger := log.NewNullLogger()
+	if logger.Writer() != io.Discard {
+		t.Errorf("Writer() = %v, want io.Discard", logger.Writer())
+	}
+	if logger.IsLevelEnabled(log.LOG_LEVEL_FATAL) {
+		t.Error("the null logger enables FATAL messages")
+	}
+
+	// nothing is formatted for the discard writer, whatever the level
+	f := &countingFormatter{}
+	logger = log.New(io.Discard, log.LOG_LEVEL_TRACE)
+	logger.SetFormatter(f)
+	logger.Error("discarded")
+	if f.count != 0 {
+		t.Errorf("formatted %d messages for io.Discard", f.count)
+	}
+}
+
+func BenchmarkNullLogger(b *testing.B) {
+	logger := log.NewNullLogger()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Errorf("Message #%d", i)
+	}
+}
+
+func BenchmarkLogger(b *testing.B) {
+	// wrapped, so the logger doesn't skip formatting for io.Discard
+	logger := log.New(struct{ io.Writer }{io.Discard}, log.LOG_LEVEL_INFO)
+	child := logger.WithFields(log.Fields{"request": 42, "user": "bob"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Info("This is a testing message.")
+		child.Info("This is a testing message with fields.")
+	}
+}
+
+// stringWriter counts the calls of its Write and WriteString methods
+type stringWriter struct {
+	writes, stringWrites int
+}
+
+func (w *stringWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+func (w *stringWriter) WriteString(s string) (int, error) {
+	w.stringWrites++
+	return len(s), nil
+}
+
+func TestWriteWithoutCopy(t *testing.T) {
+	w := &stringWriter{}
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	record := log.LogRecord{Time: time.Now(), Level: log.LOG_LEVEL_INFO, Message: strings.Repeat("x", 500)}
+
+	// the message is formatted into a pooled buffer whose bytes are passed to Write as they are, so
+	// neither a string conversion nor a copy allocates
+	n := testing.AllocsPerRun(100, func() { logger.LogRecord(record) })
+	if n != 0 && !raceEnabled {
+		t.Errorf("%v allocations per message written to an io.StringWriter", n)
+	}
+	if w.writes != 101 || w.stringWrites != 0 {
+		t.Errorf("%d calls of Write and %d of WriteString for 101 messages, want only Write", w.writes, w.stringWrites)
+	}
+}
+
+func BenchmarkStringWriter(b *testing.B) {
+	logger := log.New(&stringWriter{}, log.LOG_LEVEL_INFO)
+	record := log.LogRecord{Time: time.Now(), Level: log.LOG_LEVEL_INFO, Message: "This is a testing message."}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.LogRecord(record)
+	}
+}
+
+// bufferFormatter writes "<level> <message>" lines into the logger's buffer
+type bufferFormatter struct {
+	formatted int
+}
+
+func (f *bufferFormatter) Format(t time.Time, level int, message string) string {
+	return "string path\n"
+}
+
+func (f *bufferFormatter) FormatTo(buf *bytes.Buffer, t time.Time, level int, message string, fields log.Fields) {
+	f.formatted++
+	fmt.Fprintf(buf, "%s %s %v\n", log.LogLevel2String(level), message, fields)
+}
+
+// mallocs returns the number of heap allocations made by fn. It runs fn only once, unlike
+// testing.AllocsPerRun, which calls it once more to warm up.
+func mallocs(fn func()) uint64 {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.Mallocs - before.Mallocs
+}
+
+func TestWarmBufferPool(t *testing.T) {
+	logger := log.New(struct{ io.Writer }{io.Discard}, log.LOG_LEVEL_INFO)
+	record := log.LogRecord{Time: time.Now(), Level: log.LOG_LEVEL_INFO, Message: strings.Repeat("x", 500)}
+
+	// two collections empty the pool
+	runtime.GC()
+	runtime.GC()
+	if n := mallocs(func() { logger.LogRecord(record) }); n == 0 {
+		t.Fatal("the first log call with an empty pool doesn't allocate a buffer")
+	}
+
+	runtime.GC()
+	runtime.GC()
+	log.WarmBufferPool(4, 1024)
+	if n := mallocs(func() { logger.LogRecord(record) }); n != 0 {
+		t.Errorf("the first log call after warming the pool made %d allocations", n)
+	}
+}
+
+func TestSetBufferSize(t *testing.T) {
+	record := log.LogRecord{Time: time.Now(), Level: log.LOG_LEVEL_INFO, Message: strings.Repeat("x", 100<<10)}
+	logger := log.New(struct{ io.Writer }{io.Discard}, log.LOG_LEVEL_INFO)
+
+	// with an empty pool every message starts with a new buffer
+	runtime.GC()
+	runtime.GC()
+	growing := mallocs(func() { logger.LogRecord(record) })
+
+	logger.SetBufferSize(len(record.Message) + 100)
+	runtime.GC()
+	runtime.GC()
+	presized := mallocs(func() { logger.LogRecord(record) })
+	if presized >= growing {
+		t.Errorf("%d allocations for a message with a presized buffer, %d without", presized, growing)
+	}
+}
+
+func TestBufferFormatter(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	f := &bufferFormatter{}
+	logger.SetFormatter(f)
+	logger.SetPrefix("> ")
+	logger.Info("first")
+	logger.WithFields(log.Fields{"k": 1}).Warn("second")
+
+	want := []string{"> INFO first map[]\n", "> WARN second map[k:1]\n"}
+	if lines := rec.Lines(); fmt.Sprint(lines) != fmt.Sprint(want) || f.formatted != 2 {
+		t.Errorf("wrote %q, want %q from FormatTo", lines, want)
+	}
+}
+
+// levelRecorder is a LevelWriter recording the level of every message
+type levelRecorder struct {
+	lineRecorder
+	levels []int
+	plain  int
+}
+
+func (w *levelRecorder) Write(data []byte) (int, error) {
+	w.plain++
+	return w.lineRecorder.Write(data)
+}
+
+func (w *levelRecorder) WriteLevel(level int, data []byte) (int, error) {
+	w.levels = append(w.levels, level)
+	return w.lineRecorder.Write(data)
+}
+
+func TestLevelWriterInterface(t *testing.T) {
+	w := &levelRecorder{}
+	logger := log.New(w, log.LOG_LEVEL_TRACE)
+	logger.Trace("trace")
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.WithFields(log.Fields{"k": "v"}).Warn("warn")
+	logger.Error("error")
+	logger.Log(log.LOG_LEVEL_FATAL, "fatal")
+
+	want := []int{log.LOG_LEVEL_TRACE, log.LOG_LEVEL_DEBUG, log.LOG_LEVEL_INFO, log.LOG_LEVEL_WARN, log.LOG_LEVEL_ERROR, log.LOG_LEVEL_FATAL}
+	if fmt.Sprint(w.levels) != fmt.Sprint(want) || w.plain != 0 {
+		t.Errorf("WriteLevel received levels %v and Write %d messages, want %v", w.levels, w.plain, want)
+	}
+
+	// the level passes through wrapping writers
+	w = &levelRecorder{}
+	logger = log.New(log.NewThrottleWriter(log.NewANSIStripWriter(w), 1<<20, time.Minute), log.LOG_LEVEL_INFO)
+	logger.Error("wrapped")
+	if fmt.Sprint(w.levels) != fmt.Sprint([]int{log.LOG_LEVEL_ERROR}) {
+		t.Errorf("wrapped LevelWriter received levels %v", w.levels)
+	}
+}
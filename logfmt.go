@@ -0,0 +1,77 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogfmtFormatter formats log messages as logfmt lines of space separated key=value pairs:
+// time=2006-01-02T15:04:05Z level=info msg="log message..." key=value
+// Values containing spaces, equals signs, quotes or control characters are quoted. Fields follow the
+// message sorted by key, and the caller reported by SetReportCaller is written as caller=file.go:42.
+type LogfmtFormatter struct {
+	// TimeLayout is the layout of the timestamp, time.RFC3339 if empty
+	TimeLayout string
+}
+
+func (f *LogfmtFormatter) Format(t time.Time, level int, message string) string {
+	return f.FormatCaller(t, level, message, nil, "")
+}
+
+func (f *LogfmtFormatter) FormatFields(t time.Time, level int, message string, fields Fields) string {
+	return f.FormatCaller(t, level, message, fields, "")
+}
+
+func (f *LogfmtFormatter) FormatCaller(t time.Time, level int, message string, fields Fields, caller string) string {
+	layout := f.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	var buf bytes.Buffer
+	writeLogfmtPair(&buf, "time", t.Format(layout))
+	writeLogfmtPair(&buf, "level", strings.ToLower(LogLevel2String(level)))
+	writeLogfmtPair(&buf, "msg", strings.TrimSuffix(message, "\n"))
+	if caller != "" {
+		writeLogfmtPair(&buf, "caller", caller)
+	}
+	for _, k := range sortedKeys(fields) {
+		v := fields[k]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		writeLogfmtPair(&buf, k, fmt.Sprint(v))
+	}
+	buf.WriteByte('\n')
+	return buf.String()
+}
+
+// writeLogfmtPair writes key=value, preceded by a space unless it is the first pair of the line
+func writeLogfmtPair(buf *bytes.Buffer, key string, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if needsLogfmtQuoting(value) {
+		buf.WriteString(strconv.Quote(value))
+	} else {
+		buf.WriteString(value)
+	}
+}
+
+// needsLogfmtQuoting reports whether value must be quoted to be parsed back as a single value
+func needsLogfmtQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
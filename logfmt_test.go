@@ -0,0 +1,36 @@
+package log_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestLogfmtFormatter(t *testing.T) {
+	f := &log.LogfmtFormatter{}
+	ts := time.Date(2014, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		message string
+		want    string
+	}{
+		{"plain", `time=2014-05-01T12:30:00Z level=warn msg=plain` + "\n"},
+		{"with spaces\n", `time=2014-05-01T12:30:00Z level=warn msg="with spaces"` + "\n"},
+		{`say "hi"`, `time=2014-05-01T12:30:00Z level=warn msg="say \"hi\""` + "\n"},
+		{"a=b", `time=2014-05-01T12:30:00Z level=warn msg="a=b"` + "\n"},
+		{"two\nlines", `time=2014-05-01T12:30:00Z level=warn msg="two\nlines"` + "\n"},
+		{"", `time=2014-05-01T12:30:00Z level=warn msg=""` + "\n"},
+	} {
+		if got := f.Format(ts, log.LOG_LEVEL_WARN, tc.message); got != tc.want {
+			t.Errorf("Format(%q) = %q, want %q", tc.message, got, tc.want)
+		}
+	}
+
+	got := f.FormatFields(ts, log.LOG_LEVEL_ERROR, "failed", log.Fields{"user": "john doe", "err": errors.New("timeout"), "attempt": 3})
+	want := `time=2014-05-01T12:30:00Z level=error msg=failed attempt=3 err=timeout user="john doe"` + "\n"
+	if got != want {
+		t.Errorf("FormatFields = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+// Package logtest sends log messages to the log of the running test. It lives in its own package so that
+// programs using the core log package don't link the testing package.
+package logtest
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiddle/log"
+)
+
+// Writer forwards every log message to the log of a test or benchmark with tb.Log, so the output is
+// attributed to the test and only shown when it fails or with go test -v. Messages written after the
+// test finished are dropped, since tb.Log would panic. It is safe for concurrent use.
+type Writer struct {
+	mutex     sync.Mutex
+	tb        testing.TB
+	done      bool
+	onFailure bool
+	lines     []string // held back until the end of the test in on-failure mode
+}
+
+// NewWriter creates a Writer logging to tb
+func NewWriter(tb testing.TB) *Writer {
+	w := &Writer{tb: tb}
+	tb.Cleanup(w.finish)
+	return w
+}
+
+// NewOnFailureWriter creates a Writer that holds the messages back until the end of the test
+// and logs them only if the test failed, which keeps the output of passing tests clean even with -v
+func NewOnFailureWriter(tb testing.TB) *Writer {
+	w := &Writer{tb: tb, onFailure: true}
+	tb.Cleanup(w.finish)
+	return w
+}
+
+// NewLogger creates a new logger writing to the log of tb, see Writer
+func NewLogger(tb testing.TB, loglevel int) *log.Logger {
+	return log.New(NewWriter(tb), loglevel)
+}
+
+// Write logs data as one entry without its trailing newlines, the test log ends the line itself
+func (w *Writer) Write(data []byte) (n int, err error) {
+	line := strings.TrimRight(string(data), "\n")
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	switch {
+	case w.done:
+	case w.onFailure:
+		w.lines = append(w.lines, line)
+	default:
+		w.tb.Helper()
+		w.tb.Log(line)
+	}
+	return len(data), nil
+}
+
+// finish runs when the test ends, logging the held back messages if the test failed
+func (w *Writer) finish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.onFailure && w.tb.Failed() {
+		w.tb.Helper()
+		for _, line := range w.lines {
+			w.tb.Log(line)
+		}
+	}
+	w.lines = nil
+	w.done = true
+}
@@ -0,0 +1,90 @@
+package logtest_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gofiddle/log"
+	"github.com/gofiddle/log/logtest"
+)
+
+// fakeTB records what a Writer passes to the test log
+type fakeTB struct {
+	testing.TB
+	logs     []string
+	helpers  int
+	cleanups []func()
+	failed   bool
+}
+
+func (tb *fakeTB) Helper() {
+	tb.helpers++
+}
+
+func (tb *fakeTB) Log(args ...interface{}) {
+	tb.logs = append(tb.logs, fmt.Sprint(args...))
+}
+
+func (tb *fakeTB) Cleanup(fn func()) {
+	tb.cleanups = append(tb.cleanups, fn)
+}
+
+func (tb *fakeTB) Failed() bool {
+	return tb.failed
+}
+
+// finish ends the test like the testing package, running the cleanup functions last added first
+func (tb *fakeTB) finish() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+func TestLogger(t *testing.T) {
+	tb := &fakeTB{}
+	logger := logtest.NewLogger(tb, log.LOG_LEVEL_INFO)
+	logger.Info("hello")
+	logger.Debug("filtered")
+	logger.Infoln("world")
+
+	if len(tb.logs) != 2 {
+		t.Fatalf("forwarded %q, want two lines", tb.logs)
+	}
+	if tb.helpers == 0 {
+		t.Error("the writer isn't marked as a test helper")
+	}
+	for i, want := range []string{"hello", "world"} {
+		if line := tb.logs[i]; !strings.HasPrefix(line, "INFO: ") || !strings.HasSuffix(line, ": "+want) {
+			t.Errorf("line %d = %q, want an INFO line ending in %q without newline", i, line, want)
+		}
+	}
+
+	// nothing may reach the test log once the test is over
+	tb.finish()
+	logger.Info("too late")
+	if len(tb.logs) != 2 {
+		t.Errorf("logged after the end of the test: %q", tb.logs[2:])
+	}
+}
+
+func TestOnFailureWriter(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		tb := &fakeTB{}
+		logger := log.New(logtest.NewOnFailureWriter(tb), log.LOG_LEVEL_INFO)
+		logger.Info("first")
+		logger.Warn("second")
+		if len(tb.logs) != 0 {
+			t.Errorf("logged %q before the end of the test", tb.logs)
+		}
+
+		tb.failed = failed
+		tb.finish()
+		if !failed && len(tb.logs) != 0 {
+			t.Errorf("passing test logged %q", tb.logs)
+		}
+		if failed && (len(tb.logs) != 2 || !strings.HasSuffix(tb.logs[0], ": first") || !strings.HasSuffix(tb.logs[1], ": second")) {
+			t.Errorf("failed test logged %q, want both messages in order", tb.logs)
+		}
+	}
+}
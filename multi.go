@@ -0,0 +1,265 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MultiWriter writes every log line to all of its writers. Unlike io.MultiWriter, a failing writer
+// doesn't keep the line from the writers after it. Writers can be added and removed while logging.
+type MultiWriter struct {
+	mutex   sync.RWMutex
+	writers []io.Writer
+}
+
+// NewMultiWriter creates a MultiWriter writing to the given writers, in order
+func NewMultiWriter(writers ...io.Writer) *MultiWriter {
+	m := &MultiWriter{}
+	for _, w := range writers {
+		m.Add(w)
+	}
+	return m
+}
+
+// NewMultiLogger creates a new logger writing every message to all of the given writers. Close closes
+// each writer that can be closed.
+func NewMultiLogger(loglevel int, writers ...io.Writer) *Logger {
+	return New(NewMultiWriter(writers...), loglevel)
+}
+
+// Add appends w to the writers
+func (m *MultiWriter) Add(w io.Writer) {
+	if w == nil {
+		return
+	}
+	m.mutex.Lock()
+	m.writers = append(m.writers, w)
+	m.mutex.Unlock()
+}
+
+// Remove removes w from the writers without closing it. It returns false if w isn't one of them.
+func (m *MultiWriter) Remove(w io.Writer) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, x := range m.writers {
+		if sameWriter(x, w) {
+			m.writers = append(m.writers[:i:i], m.writers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Writers returns a copy of the current writers
+func (m *MultiWriter) Writers() []io.Writer {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]io.Writer(nil), m.writers...)
+}
+
+// has reports whether w is one of the writers
+func (m *MultiWriter) has(w io.Writer) bool {
+	for _, x := range m.Writers() {
+		if sameWriter(x, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes data to every writer. If some of them fail, the error lists their errors; it doesn't
+// wrap them, so a broken pipe on one destination doesn't make the logger replace all of them.
+func (m *MultiWriter) Write(data []byte) (n int, err error) {
+	return m.WriteLevel(0, data)
+}
+
+// WriteLevel is Write for a message at the given level, which is passed on to the writers that are
+// LevelWriters
+func (m *MultiWriter) WriteLevel(level int, data []byte) (n int, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var errs []string
+	for _, w := range m.writers {
+		if _, err := writeLevel(w, level, data); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return len(data), m.failed(errs)
+}
+
+// failed combines the errors of the writers that failed
+func (m *MultiWriter) failed(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("log: %d of %d writers failed: %s", len(errs), len(m.writers), strings.Join(errs, "; "))
+}
+
+// Flush flushes every writer that implements Flusher
+func (m *MultiWriter) Flush() error {
+	return m.each(func(w io.Writer) error { return flushWriter(w) })
+}
+
+// Sync syncs every writer that implements Syncer
+func (m *MultiWriter) Sync() error {
+	return m.each(syncWriter)
+}
+
+// Close closes every writer that can be closed
+func (m *MultiWriter) Close() error {
+	return m.each(closeInner)
+}
+
+// each calls fn for every writer, carrying on when it fails
+func (m *MultiWriter) each(fn func(w io.Writer) error) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var errs []string
+	for _, w := range m.writers {
+		if err := fn(w); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return m.failed(errs)
+}
+
+// AddWriter makes the logger write every message to w as well as to its current writers. w is closed
+// along with the logger's writer if it can be closed.
+func (logger *Logger) AddWriter(w io.Writer) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	if m, ok := logger.writer.(*MultiWriter); ok {
+		m.Add(w)
+		return
+	}
+	m := NewMultiWriter(logger.writer, w)
+	if closeFn := logger.closeFn; closeFn != nil {
+		// the current writer keeps its own cleanup, the added writers are closed after it
+		prev := logger.writer
+		logger.closeFn = func() error {
+			err := closeFn()
+			m.Remove(prev)
+			if e := m.Close(); err == nil {
+				err = e
+			}
+			return err
+		}
+	} else if logger.writeCloser == nil && logger.writer != nil {
+		// the logger doesn't own its current writer, so only the added writers must be closed
+		prev := logger.writer
+		logger.closeFn = func() error {
+			m.Remove(prev)
+			return m.Close()
+		}
+	}
+	logger.writer = m
+	logger.writeCloser = m
+}
+
+// RemoveWriter stops the logger from writing to w, which was added with AddWriter or passed to
+// NewMultiLogger. w is not closed. It returns false if the logger doesn't write to w.
+func (logger *Logger) RemoveWriter(w io.Writer) bool {
+	m, ok := logger.Writer().(*MultiWriter)
+	return ok && m.Remove(w)
+}
+
+// Tee makes the logger also write every message at or above minLevel to secondary, e.g. errors to an
+// alerting endpoint while everything goes to a file. Messages below the level of the logger are not
+// written anywhere. secondary is closed along with the logger's writer if it can be closed.
+func (logger *Logger) Tee(secondary io.Writer, minLevel int) {
+	logger.AddWriter(&levelFilterWriter{w: secondary, minLevel: minLevel})
+}
+
+// levelFilterWriter passes on the messages at or above minLevel. Data written without a level is
+// dropped since its level is unknown.
+type levelFilterWriter struct {
+	w        io.Writer
+	minLevel int
+}
+
+func (w *levelFilterWriter) Write(data []byte) (n int, err error) {
+	return len(data), nil
+}
+
+func (w *levelFilterWriter) WriteLevel(level int, data []byte) (n int, err error) {
+	if level < w.minLevel {
+		return len(data), nil
+	}
+	return writeLevel(w.w, level, data)
+}
+
+func (w *levelFilterWriter) Flush() error {
+	return flushWriter(w.w)
+}
+
+func (w *levelFilterWriter) Sync() error {
+	return syncWriter(w.w)
+}
+
+func (w *levelFilterWriter) Close() error {
+	return closeInner(w.w)
+}
+
+// SplitWriter routes each message by its level: messages at or above a threshold go to one writer, the
+// others to another, e.g. errors to stderr and the rest to stdout. It is a LevelWriter; data written
+// with plain Write has no level and goes to the writer for lower levels.
+type SplitWriter struct {
+	out       io.Writer
+	errOut    io.Writer
+	threshold int
+}
+
+// NewSplitWriter creates a SplitWriter writing messages at or above threshold to errOut, and the
+// others to out
+func NewSplitWriter(out, errOut io.Writer, threshold int) *SplitWriter {
+	return &SplitWriter{out: out, errOut: errOut, threshold: threshold}
+}
+
+// NewSplitLogger creates a new logger writing messages at or above threshold to errOut and the others to
+// out, e.g. NewSplitLogger(os.Stdout, os.Stderr, LOG_LEVEL_WARN, LOG_LEVEL_INFO). Close closes both
+// writers if they can be closed.
+func NewSplitLogger(out, errOut io.Writer, threshold int, loglevel int) *Logger {
+	return New(NewSplitWriter(out, errOut, threshold), loglevel)
+}
+
+func (w *SplitWriter) Write(data []byte) (n int, err error) {
+	return w.out.Write(data)
+}
+
+// WriteLevel writes data to the writer for the level
+func (w *SplitWriter) WriteLevel(level int, data []byte) (n int, err error) {
+	if level >= w.threshold {
+		return writeLevel(w.errOut, level, data)
+	}
+	return writeLevel(w.out, level, data)
+}
+
+// Flush flushes both writers that implement Flusher
+func (w *SplitWriter) Flush() error {
+	return w.both(flushWriter)
+}
+
+// Sync syncs both writers that implement Syncer
+func (w *SplitWriter) Sync() error {
+	return w.both(syncWriter)
+}
+
+// Close closes both writers that can be closed, the same writer only once
+func (w *SplitWriter) Close() error {
+	return w.both(closeInner)
+}
+
+// both calls fn for both writers, once if they are the same
+func (w *SplitWriter) both(fn func(w io.Writer) error) error {
+	err := fn(w.out)
+	if sameWriter(w.out, w.errOut) {
+		return err
+	}
+	if e := fn(w.errOut); err == nil {
+		err = e
+	}
+	return err
+}
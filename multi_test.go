@@ -0,0 +1,118 @@
+package log_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	log "."
+)
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestMultiLogger(t *testing.T) {
+	var plain bytes.Buffer
+	closer := &closeRecorder{}
+	broken := &brokenPipeWriter{}
+	logger := log.NewMultiLogger(log.LOG_LEVEL_INFO, broken, &plain, closer)
+
+	logger.Info("fan out")
+	for name, got := range map[string]string{"buffer": plain.String(), "closer": closer.String()} {
+		if !strings.HasSuffix(got, ": fan out\n") {
+			t.Errorf("%s received %q", name, got)
+		}
+	}
+	if !logger.HasWriter(&plain) || broken.writes != 1 {
+		t.Errorf("HasWriter = %v, failing writer written %d times", logger.HasWriter(&plain), broken.writes)
+	}
+
+	if !logger.RemoveWriter(&plain) || logger.RemoveWriter(&plain) {
+		t.Error("RemoveWriter should remove the buffer exactly once")
+	}
+	logger.Info("after removal")
+	if strings.Contains(plain.String(), "after removal") || !strings.Contains(closer.String(), "after removal") {
+		t.Errorf("removed writer still written: %q", plain.String())
+	}
+
+	logger.Close()
+	if !closer.closed {
+		t.Error("Close didn't close the writers")
+	}
+}
+
+func TestAddWriter(t *testing.T) {
+	var first bytes.Buffer
+	second := &closeRecorder{}
+	logger := log.New(&first, log.LOG_LEVEL_INFO)
+	logger.AddWriter(second)
+
+	logger.Warn("both")
+	if !strings.HasSuffix(first.String(), ": both\n") || !strings.HasSuffix(second.String(), ": both\n") {
+		t.Errorf("writers received %q and %q", first.String(), second.String())
+	}
+	logger.Close()
+	if !second.closed {
+		t.Error("Close didn't close the added writer")
+	}
+}
+
+func TestSplitLogger(t *testing.T) {
+	out, errOut := &closeRecorder{}, &closeRecorder{}
+	logger := log.NewSplitLogger(out, errOut, log.LOG_LEVEL_WARN, log.LOG_LEVEL_DEBUG)
+	logger.Trace("filtered")
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.WithFields(log.Fields{"k": "v"}).Warn("warn")
+	logger.Error("error")
+	logger.Log(log.LOG_LEVEL_FATAL, "fatal")
+
+	levels := func(s string) []string {
+		var levels []string
+		for _, line := range strings.SplitAfter(strings.TrimSpace(s), "\n") {
+			levels = append(levels, line[:strings.Index(line, ":")])
+		}
+		return levels
+	}
+	if got := levels(out.String()); fmt.Sprint(got) != "[DEBUG INFO]" {
+		t.Errorf("out received %v", got)
+	}
+	if got := levels(errOut.String()); fmt.Sprint(got) != "[WARN ERROR FATAL]" {
+		t.Errorf("errOut received %v", got)
+	}
+	logger.Close()
+	if !out.closed || !errOut.closed {
+		t.Error("Close didn't close both writers")
+	}
+}
+
+func TestTee(t *testing.T) {
+	primary, alerts := &closeRecorder{}, &closeRecorder{}
+	logger := log.New(primary, log.LOG_LEVEL_INFO)
+	logger.Tee(alerts, log.LOG_LEVEL_ERROR)
+	logger.Debug("filtered")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.WithFields(log.Fields{"k": "v"}).Error("error")
+	logger.Log(log.LOG_LEVEL_FATAL, "fatal")
+
+	if n := strings.Count(primary.String(), "\n"); n != 4 {
+		t.Errorf("primary received %d lines, want 4: %q", n, primary.String())
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(alerts.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ERROR: ") || !strings.HasSuffix(lines[0], ": error k=v\n") || !strings.HasPrefix(lines[1], "FATAL: ") {
+		t.Errorf("secondary received %q, want the ERROR and FATAL lines", lines)
+	}
+	logger.Close()
+	if !primary.closed || !alerts.closed {
+		t.Error("Close didn't close both writers")
+	}
+}
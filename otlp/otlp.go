@@ -0,0 +1,229 @@
+// Package otlp exports log messages to an OpenTelemetry collector using the OTLP/HTTP JSON protocol.
+// It lives in its own package so the core log package stays free of any OpenTelemetry specifics.
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiddle/log"
+)
+
+// DEFAULT_ENDPOINT is the default OTLP/HTTP logs endpoint of a local collector
+const DEFAULT_ENDPOINT = "http://localhost:4318/v1/logs"
+
+// SCOPE_NAME is the instrumentation scope name reported with every exported record
+const SCOPE_NAME = "github.com/gofiddle/log"
+
+// SeverityNumber maps a log level to the OpenTelemetry severity number (1-24). It is a log.SeverityFunc.
+func SeverityNumber(level int) int {
+	switch level {
+	case log.LOG_LEVEL_TRACE:
+		return 1
+	case log.LOG_LEVEL_DEBUG:
+		return 5
+	case log.LOG_LEVEL_INFO:
+		return 9
+	case log.LOG_LEVEL_WARN:
+		return 13
+	case log.LOG_LEVEL_ERROR:
+		return 17
+	case log.LOG_LEVEL_FATAL:
+		return 21
+	default:
+		return 0
+	}
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type logRecord struct {
+	TimeUnixNano   string     `json:"timeUnixNano"`
+	SeverityNumber int        `json:"severityNumber"`
+	SeverityText   string     `json:"severityText"`
+	Body           anyValue   `json:"body"`
+	Attributes     []keyValue `json:"attributes,omitempty"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type scopeLogs struct {
+	Scope      scope       `json:"scope"`
+	LogRecords []logRecord `json:"logRecords"`
+}
+
+type resourceLogs struct {
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+type exportLogsServiceRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+func stringValue(s string) anyValue {
+	return anyValue{StringValue: &s}
+}
+
+// intValue returns an AnyValue holding an integer, encoded as a string as required by the protobuf JSON
+// mapping of int64
+func intValue(i int64) anyValue {
+	s := strconv.FormatInt(i, 10)
+	return anyValue{IntValue: &s}
+}
+
+// toAnyValue converts a go value to an OTLP AnyValue. All integer types map to intValue, except unsigned
+// values beyond the range of int64, which are exported as strings.
+func toAnyValue(v interface{}) anyValue {
+	switch x := v.(type) {
+	case string:
+		return stringValue(x)
+	case bool:
+		return anyValue{BoolValue: &x}
+	case int:
+		return intValue(int64(x))
+	case int8:
+		return intValue(int64(x))
+	case int16:
+		return intValue(int64(x))
+	case int32:
+		return intValue(int64(x))
+	case int64:
+		return intValue(x)
+	case uint:
+		return uintValue(uint64(x))
+	case uint8:
+		return intValue(int64(x))
+	case uint16:
+		return intValue(int64(x))
+	case uint32:
+		return intValue(int64(x))
+	case uint64:
+		return uintValue(x)
+	case float32:
+		f := float64(x)
+		return anyValue{DoubleValue: &f}
+	case float64:
+		return anyValue{DoubleValue: &x}
+	default:
+		return stringValue(fmt.Sprint(x))
+	}
+}
+
+// uintValue returns an AnyValue holding an unsigned integer, or its decimal string if it doesn't fit into
+// the int64 of intValue
+func uintValue(u uint64) anyValue {
+	if u > math.MaxInt64 {
+		return stringValue(strconv.FormatUint(u, 10))
+	}
+	return intValue(int64(u))
+}
+
+// Formatter renders each log message as a single line OTLP ExportLogsServiceRequest JSON document.
+// Fields of the message are exported as attributes of the record.
+type Formatter struct {
+	// Attributes are attached to every exported LogRecord
+	Attributes map[string]interface{}
+	// Severity maps levels to severity numbers, SeverityNumber is used if nil
+	Severity log.SeverityFunc
+}
+
+func (f *Formatter) Format(t time.Time, level int, message string) string {
+	return f.FormatFields(t, level, message, nil)
+}
+
+// FormatFields renders the fields of the message as record attributes, on top of the configured Attributes
+func (f *Formatter) FormatFields(t time.Time, level int, message string, fields log.Fields) string {
+	severity := f.Severity
+	if severity == nil {
+		severity = SeverityNumber
+	}
+	record := logRecord{
+		TimeUnixNano:   strconv.FormatInt(t.UnixNano(), 10),
+		SeverityNumber: severity(level),
+		SeverityText:   log.LogLevel2String(level),
+		Body:           stringValue(strings.TrimSuffix(message, "\n")),
+	}
+	attrs := make(map[string]interface{}, len(f.Attributes)+len(fields))
+	for k, v := range f.Attributes {
+		attrs[k] = v
+	}
+	for k, v := range fields {
+		attrs[k] = v
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		record.Attributes = append(record.Attributes, keyValue{Key: k, Value: toAnyValue(attrs[k])})
+	}
+
+	req := exportLogsServiceRequest{
+		ResourceLogs: []resourceLogs{{
+			ScopeLogs: []scopeLogs{{
+				Scope:      scope{Name: SCOPE_NAME},
+				LogRecords: []logRecord{record},
+			}},
+		}},
+	}
+	data, err := json.Marshal(&req)
+	if err != nil {
+		return ""
+	}
+	return string(data) + "\n"
+}
+
+// Writer posts OTLP JSON documents to a collector endpoint.
+type Writer struct {
+	url    string
+	client *http.Client
+}
+
+// NewWriter creates a Writer that exports to the given OTLP/HTTP logs endpoint
+func NewWriter(endpoint string) *Writer {
+	if endpoint == "" {
+		endpoint = DEFAULT_ENDPOINT
+	}
+	return &Writer{url: endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *Writer) Write(data []byte) (n int, err error) {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// the collector replies 200 with an ExportLogsServiceResponse on success
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("otlp: collector returned %s", resp.Status)
+	}
+	return len(data), nil
+}
+
+// NewLogger creates a logger that exports its messages asynchronously to an OTLP collector
+func NewLogger(endpoint string, loglevel int) *log.Logger {
+	logger := log.New(log.NewAsyncLogWriter(NewWriter(endpoint), log.DEFAULT_QUEUE_SIZE), loglevel)
+	logger.SetFormatter(&Formatter{})
+	return logger
+}
@@ -0,0 +1,102 @@
+package otlp_test
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiddle/log"
+	"github.com/gofiddle/log/otlp"
+)
+
+type exportRequest struct {
+	ResourceLogs []struct {
+		ScopeLogs []struct {
+			LogRecords []struct {
+				SeverityNumber int    `json:"severityNumber"`
+				SeverityText   string `json:"severityText"`
+				Body           struct {
+					StringValue string `json:"stringValue"`
+				} `json:"body"`
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+						IntValue    string `json:"intValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+func TestOTLPExport(t *testing.T) {
+	received := make(chan exportRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- req
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	logger := log.New(otlp.NewWriter(server.URL), log.LOG_LEVEL_DEBUG)
+	logger.SetFormatter(&otlp.Formatter{Attributes: map[string]interface{}{"service": "api", "pid": 42}})
+	logger.WithFields(log.Fields{"disk": "/dev/sda1"}).Error("disk full")
+
+	req := <-received
+	if len(req.ResourceLogs) != 1 || len(req.ResourceLogs[0].ScopeLogs) != 1 || len(req.ResourceLogs[0].ScopeLogs[0].LogRecords) != 1 {
+		t.Fatalf("unexpected export request: %+v", req)
+	}
+	record := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if record.SeverityNumber != 17 || record.SeverityText != "ERROR" {
+		t.Errorf("severity = %d %q, want 17 ERROR", record.SeverityNumber, record.SeverityText)
+	}
+	if record.Body.StringValue != "disk full" {
+		t.Errorf("body = %q, want %q", record.Body.StringValue, "disk full")
+	}
+	attrs := map[string]string{}
+	for _, kv := range record.Attributes {
+		attrs[kv.Key] = kv.Value.StringValue + kv.Value.IntValue
+	}
+	if attrs["service"] != "api" || attrs["pid"] != "42" || attrs["disk"] != "/dev/sda1" {
+		t.Errorf("attributes = %v, want service=api pid=42 disk=/dev/sda1", attrs)
+	}
+}
+
+func TestOTLPIntegers(t *testing.T) {
+	fields := log.Fields{
+		"int8": int8(-8), "int16": int16(-16), "uint": uint(7), "uint8": uint8(8), "uint16": uint16(16),
+		"uint32": uint32(32), "uint64": uint64(64), "huge": uint64(math.MaxUint64),
+	}
+	line := (&otlp.Formatter{}).FormatFields(time.Now(), log.LOG_LEVEL_INFO, "counters", fields)
+	var req exportRequest
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	ints, strs := map[string]string{}, map[string]string{}
+	for _, kv := range req.ResourceLogs[0].ScopeLogs[0].LogRecords[0].Attributes {
+		if kv.Value.IntValue != "" {
+			ints[kv.Key] = kv.Value.IntValue
+		} else {
+			strs[kv.Key] = kv.Value.StringValue
+		}
+	}
+	want := map[string]string{"int8": "-8", "int16": "-16", "uint": "7", "uint8": "8", "uint16": "16", "uint32": "32", "uint64": "64"}
+	for k, v := range want {
+		if ints[k] != v {
+			t.Errorf("%s exported as intValue %q, want %q", k, ints[k], v)
+		}
+	}
+	// beyond int64 the value is kept exactly as a string
+	if len(ints) != len(want) || strs["huge"] != "18446744073709551615" {
+		t.Errorf("got int attributes %v and string attributes %v", ints, strs)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package log_test
+
+// raceEnabled is set with the race detector, which makes sync.Pool drop buffers at random
+const raceEnabled = true
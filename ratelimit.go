@@ -0,0 +1,66 @@
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter is a token bucket allowing rate messages per second with bursts of up to burst messages
+type rateLimiter struct {
+	dropped uint64 // accessed atomically
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+}
+
+// allow takes a token from the bucket, it returns false and counts the message as dropped if the
+// bucket is empty
+func (l *rateLimiter) allow() bool {
+	l.mutex.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		l.mutex.Unlock()
+		return true
+	}
+	l.mutex.Unlock()
+	atomic.AddUint64(&l.dropped, 1)
+	return false
+}
+
+// SetRateLimit caps the logger at perSecond messages per second on average, allowing bursts of up to
+// burst messages. Messages over the limit are dropped before they are formatted and counted by
+// DroppedByRateLimit. The limit is shared with the WithFields children of the logger. A perSecond of 0
+// removes the limit.
+func (logger *Logger) SetRateLimit(perSecond int, burst int) {
+	var limiter *rateLimiter
+	if perSecond > 0 {
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = &rateLimiter{rate: float64(perSecond), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+	}
+	logger.mutex.Lock()
+	logger.limiter = limiter
+	logger.mutex.Unlock()
+}
+
+// DroppedByRateLimit returns the number of messages dropped by the rate limit set with SetRateLimit,
+// since it was set
+func (logger *Logger) DroppedByRateLimit() uint64 {
+	logger.mutex.Lock()
+	limiter := logger.limiter
+	logger.mutex.Unlock()
+	if limiter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&limiter.dropped)
+}
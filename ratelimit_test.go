@@ -0,0 +1,38 @@
+package log_test
+
+import (
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestRateLimit(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	logger.SetRateLimit(100, 10)
+
+	start := time.Now()
+	logged := 0
+	for time.Since(start) < 200*time.Millisecond {
+		logger.Info("flood")
+		logged++
+	}
+	elapsed := time.Since(start)
+
+	// the burst, plus 100 messages per second
+	max := 10 + int(elapsed.Seconds()*100) + 1
+	written := len(rec.Lines())
+	if written < 10 || written > max {
+		t.Errorf("wrote %d of %d messages in %v, want 10 to %d", written, logged, elapsed, max)
+	}
+	if dropped := logger.DroppedByRateLimit(); int(dropped) != logged-written {
+		t.Errorf("DroppedByRateLimit = %d, want %d", dropped, logged-written)
+	}
+
+	logger.SetRateLimit(0, 0)
+	logger.Info("unlimited")
+	if len(rec.Lines()) != written+1 || logger.DroppedByRateLimit() != 0 {
+		t.Error("removing the rate limit didn't let messages through")
+	}
+}
@@ -0,0 +1,65 @@
+package log
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// RingBufferWriter keeps the most recent log lines in memory, dropping the oldest ones, e.g. to serve
+// them from an admin endpoint. Data written in one Write is split into lines, so it works behind a
+// batching AsyncLogWriter too. It is safe for concurrent use.
+type RingBufferWriter struct {
+	mutex sync.Mutex
+	lines []string
+	next  int // index of the slot for the next line
+	full  bool
+}
+
+// NewRingBufferWriter creates a RingBufferWriter keeping the last n lines
+func NewRingBufferWriter(n int) *RingBufferWriter {
+	if n < 1 {
+		n = 1
+	}
+	return &RingBufferWriter{lines: make([]string, n)}
+}
+
+func (w *RingBufferWriter) Write(data []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	s := string(data)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			i = len(s) - 1
+		}
+		w.lines[w.next] = s[:i+1]
+		s = s[i+1:]
+		w.next++
+		if w.next == len(w.lines) {
+			w.next = 0
+			w.full = true
+		}
+	}
+	return len(data), nil
+}
+
+// Lines returns the retained lines, oldest first, each with its trailing newline
+func (w *RingBufferWriter) Lines() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.full {
+		return append([]string(nil), w.lines[:w.next]...)
+	}
+	return append(append([]string(nil), w.lines[w.next:]...), w.lines[:w.next]...)
+}
+
+// Dump writes the retained lines to out, oldest first
+func (w *RingBufferWriter) Dump(out io.Writer) error {
+	for _, line := range w.Lines() {
+		if _, err := io.WriteString(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package log_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	log "."
+)
+
+func TestRingBufferWriter(t *testing.T) {
+	w := log.NewRingBufferWriter(10)
+	if lines := w.Lines(); len(lines) != 0 {
+		t.Errorf("empty buffer has lines %q", lines)
+	}
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+	if lines := w.Lines(); len(lines) != 5 || lines[0] != "line 0\n" {
+		t.Errorf("got %q, want lines 0 to 4", lines)
+	}
+
+	// a write with several lines, as from a batching writer
+	fmt.Fprintf(w, "line 5\nline 6\n")
+	for i := 7; i < 20; i++ {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+	lines := w.Lines()
+	if len(lines) != 10 {
+		t.Fatalf("got %d lines, want 10", len(lines))
+	}
+	for i, line := range lines {
+		if want := fmt.Sprintf("line %d\n", 10+i); line != want {
+			t.Errorf("line %d is %q, want %q", i, line, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	w.Dump(&buf)
+	if buf.String() != strings.Join(lines, "") {
+		t.Errorf("Dump wrote %q", buf.String())
+	}
+}
+
+func TestRingBufferWriterConcurrent(t *testing.T) {
+	w := log.NewRingBufferWriter(50)
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.Infof("Message #%d", j)
+				w.Lines()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(w.Lines()); n != 50 {
+		t.Errorf("got %d lines, want 50", n)
+	}
+}
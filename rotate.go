@@ -0,0 +1,357 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotatingFileWriter writes to a log file and rotates it by size. When a write would grow the file beyond
+// MaxBytes, the file is renamed to <filename>.1, older backups are shifted up to <filename>.<MaxBackups>,
+// the oldest one is removed and a fresh file is opened. Rotation only happens between writes, so a line
+// is never split across files. It is safe for concurrent use and, being a SyncWriteCloser, can be used
+// standalone.
+type RotatingFileWriter struct {
+	mutex      sync.Mutex
+	filename   string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	header     []byte
+	compress   bool
+	closed     bool
+}
+
+// NewRotatingFileWriter opens filename for appending, rotating it when it exceeds maxBytes and keeping
+// up to maxBackups rotated files
+func NewRotatingFileWriter(filename string, maxBytes int64, maxBackups int) (*RotatingFileWriter, error) {
+	w := &RotatingFileWriter{
+		filename:   filename,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewRotatingFileLogger creates a new logger which writes logs to the specified logpath and filename,
+// rotating the log file when it exceeds maxBytes and keeping up to maxBackups old files
+func NewRotatingFileLogger(logpath string, fname string, loglevel int, maxBytes int, maxBackups int) (*Logger, error) {
+	fname, filepath, err := prepareLogFile(logpath, fname)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewRotatingFileWriter(filepath, int64(maxBytes), maxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := New(w, loglevel)
+	logger.path = logpath
+	logger.fname = fname
+	return logger, nil
+}
+
+// SetHeader sets a line written at the top of every new log file, before its first message, e.g. a
+// schema marker like {"_schema":"v1"} for JSON Lines files. A newline is appended if missing.
+func (w *RotatingFileWriter) SetHeader(header string) {
+	w.mutex.Lock()
+	w.header = headerLine(header)
+	w.mutex.Unlock()
+}
+
+// SetCompress makes the writer gzip every rotated file into <filename>.1.gz and shift the compressed
+// backups up to <filename>.<MaxBackups>.gz. The compression runs during the rotation, delaying the write
+// that triggered it. If it fails, the backup is kept uncompressed.
+func (w *RotatingFileWriter) SetCompress(compress bool) {
+	w.mutex.Lock()
+	w.compress = compress
+	w.mutex.Unlock()
+}
+
+// headerLine returns header terminated by a newline, or nil if it's empty
+func headerLine(header string) []byte {
+	if header == "" {
+		return nil
+	}
+	if header[len(header)-1] != '\n' {
+		header += "\n"
+	}
+	return []byte(header)
+}
+
+// open opens the log file for appending and picks up its current size
+func (w *RotatingFileWriter) open() error {
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, DEFAULT_FILE_MODE)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// backupName returns the name of the n-th rotated file
+func (w *RotatingFileWriter) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", w.filename, n)
+}
+
+// rotate shifts the backups, moves the current file to the first backup and opens a new file. If that
+// fails, it reopens the log file, whichever file that is by then, so the writer isn't left with a closed
+// file; w.file is nil only if the reopen failed as well.
+func (w *RotatingFileWriter) rotate() error {
+	err := w.file.Close()
+	if err == nil {
+		err = w.shift()
+	}
+	if err == nil {
+		err = w.open()
+	}
+	if err != nil {
+		w.file = nil
+		w.open()
+	}
+	return err
+}
+
+// shift shifts the backups and moves the current file to the first backup
+func (w *RotatingFileWriter) shift() error {
+	if w.maxBackups > 0 {
+		for n := w.maxBackups - 1; n >= 1; n-- {
+			// missing backups are fine, there might not have been that many rotations yet
+			os.Rename(w.backupName(n), w.backupName(n+1))
+			os.Rename(w.backupName(n)+".gz", w.backupName(n+1)+".gz")
+		}
+		if err := os.Rename(w.filename, w.backupName(1)); err != nil {
+			return err
+		}
+		if w.compress {
+			gzipFile(w.backupName(1), w.backupName(1)+".gz")
+		}
+	} else if err := os.Remove(w.filename); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Write writes data to the log file, rotating it first if needed. If the rotation fails, data is still
+// written to the reopened file, which grows beyond MaxBytes until a later write rotates it successfully,
+// and the rotation error is returned.
+func (w *RotatingFileWriter) Write(data []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.file == nil {
+		// a failed rotation couldn't reopen the file either, try again
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	var rotateErr error
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(data)) > w.maxBytes {
+		if rotateErr = w.rotate(); w.file == nil {
+			return 0, rotateErr
+		}
+	}
+	if w.size == 0 && len(w.header) > 0 {
+		hn, err := w.file.Write(w.header)
+		w.size += int64(hn)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = w.file.Write(data)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Sync commits the current log file to stable storage
+func (w *RotatingFileWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed || w.file == nil {
+		return os.ErrClosed
+	}
+	return w.file.Sync()
+}
+
+// Close closes the current log file
+func (w *RotatingFileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// DailyFileWriter writes to a log file per calendar day named <name>-2006-01-02.log. The day is checked
+// lazily at each write: the first write on a new day closes the old file and opens the new one, exactly
+// once even under concurrent writes. Days are counted in a given time zone. If the file of a new day
+// can't be opened, the write fails and the next one tries again.
+type DailyFileWriter struct {
+	mutex  sync.Mutex
+	dir    string
+	name   string
+	loc    *time.Location
+	now    func() time.Time
+	day    string
+	file   *os.File
+	empty  bool // the current file has no content yet
+	header []byte
+	closed bool
+}
+
+// NewDailyFileWriter creates a DailyFileWriter writing into dir. With utc set, the day changes at
+// midnight UTC, otherwise at local midnight.
+func NewDailyFileWriter(dir string, name string, utc bool) (*DailyFileWriter, error) {
+	loc := time.Local
+	if utc {
+		loc = time.UTC
+	}
+	return NewDailyFileWriterIn(dir, name, loc)
+}
+
+// NewDailyFileWriterIn creates a DailyFileWriter writing into dir whose day changes at midnight in loc;
+// a nil loc means UTC. DefaultLogFormatter stamps messages in UTC or local time only, so with another
+// zone the dates in the file names and in the lines disagree for part of the day.
+func NewDailyFileWriterIn(dir string, name string, loc *time.Location) (*DailyFileWriter, error) {
+	if err := checkLogName(name); err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	w := &DailyFileWriter{dir: dir, name: name, loc: loc, now: time.Now}
+	if err := w.openDay(w.today()); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewDailyFileLogger creates a new logger which writes logs to a new file in logpath every day. Days
+// change at midnight UTC, the time zone used by DefaultLogFormatter.
+func NewDailyFileLogger(logpath string, fname string, loglevel int) (*Logger, error) {
+	return NewDailyFileLoggerIn(logpath, fname, loglevel, time.UTC)
+}
+
+// NewDailyFileLoggerIn is NewDailyFileLogger with days changing at midnight in loc; a nil loc means UTC.
+// With time.Local the logger's DefaultLogFormatter stamps messages in local time too, so file names and
+// timestamps agree. Other zones are used for the file names only, see NewDailyFileWriterIn.
+func NewDailyFileLoggerIn(logpath string, fname string, loglevel int, loc *time.Location) (*Logger, error) {
+	fname, _, err := prepareLogFile(logpath, fname)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewDailyFileWriterIn(logpath, fname, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := New(w, loglevel)
+	logger.path = logpath
+	logger.fname = fname
+	if loc == time.Local {
+		logger.formatter = &DefaultLogFormatter{Local: true}
+	}
+	return logger, nil
+}
+
+// SetHeader sets a line written at the top of every new log file, before its first message. A newline
+// is appended if missing.
+func (w *DailyFileWriter) SetHeader(header string) {
+	w.mutex.Lock()
+	w.header = headerLine(header)
+	w.mutex.Unlock()
+}
+
+// today returns the current date in the writer's time zone
+func (w *DailyFileWriter) today() string {
+	return w.now().In(w.loc).Format("2006-01-02")
+}
+
+// openDay opens the log file of the given day for appending
+func (w *DailyFileWriter) openDay(day string) error {
+	filename := filepath.Join(w.dir, w.name+"-"+day+".log")
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, DEFAULT_FILE_MODE)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.day = day
+	w.empty = info.Size() == 0
+	return nil
+}
+
+func (w *DailyFileWriter) Write(data []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if day := w.today(); w.file == nil || day != w.day {
+		if w.file != nil {
+			w.file.Close()
+			w.file = nil
+		}
+		// without a file the next write tries again
+		if err := w.openDay(day); err != nil {
+			return 0, err
+		}
+	}
+	if w.empty && len(w.header) > 0 {
+		if _, err := w.file.Write(w.header); err != nil {
+			return 0, err
+		}
+	}
+	w.empty = false
+	return w.file.Write(data)
+}
+
+// Sync commits the current log file to stable storage
+func (w *DailyFileWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed || w.file == nil {
+		return os.ErrClosed
+	}
+	return w.file.Sync()
+}
+
+// Close closes the current log file
+func (w *DailyFileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
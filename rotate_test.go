@@ -0,0 +1,158 @@
+package log_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "."
+)
+
+func TestRotatingFileLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := log.NewRotatingFileLogger(dir, "app", log.LOG_LEVEL_INFO, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		logger.Infof("Message #%02d", i)
+	}
+	logger.Close()
+
+	// the last message must be in the current file, and the previous ones in the backups
+	lines := 0
+	for _, name := range []string{"app.log", "app.log.1", "app.log.2"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 200 || !strings.HasSuffix(string(data), "\n") {
+			t.Errorf("%s has %d bytes or a partial line", name, len(data))
+		}
+		lines += strings.Count(string(data), "\n")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.log.3")); !os.IsNotExist(err) {
+		t.Errorf("more than 2 backups kept: %v", err)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "app.log"))
+	if !strings.Contains(string(data), "Message #19") {
+		t.Errorf("latest message not in the current file: %q", data)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(dir, "app.log.2"))
+	if want := fmt.Sprintf("Message #%02d", 19-lines+1); !strings.Contains(string(data), want) {
+		t.Errorf("oldest backup doesn't continue where the others end, want %q in %q", want, data)
+	}
+}
+
+func TestRotatingFileWriterStandalone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rw, err := log.NewRotatingFileWriter(filepath.Join(dir, "out.log"), 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w log.SyncWriteCloser = rw
+
+	fmt.Fprintln(w, "written by another library")
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("too late\n")); err == nil {
+		t.Error("Write after Close should fail")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "out.log"))
+	if err != nil || string(data) != "written by another library\n" {
+		t.Errorf("file contains %q (%v)", data, err)
+	}
+}
+
+func TestRotatingFileWriterHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := log.NewRotatingFileWriter(filepath.Join(dir, "app.jsonl"), 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetHeader(`{"_schema":"v1"}`)
+	logger := log.New(w, log.LOG_LEVEL_INFO)
+	logger.SetFormatter(&log.JSONFormatter{})
+	for i := 0; i < 3; i++ {
+		logger.Infof("Message #%d", i)
+	}
+	logger.Close()
+
+	// every message is longer than half the limit, so each file holds the header and one message
+	for _, name := range []string{"app.jsonl", "app.jsonl.1"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(lines) != 2 || lines[0] != `{"_schema":"v1"}` {
+			t.Errorf("%s contains %q, want the header followed by one message", name, data)
+		}
+	}
+}
+
+func TestRotatingFileWriterRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a non-empty directory in place of the backup makes the rename fail
+	filename := filepath.Join(dir, "app.log")
+	if err := os.MkdirAll(filepath.Join(filename+".1", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := log.NewRotatingFileWriter(filename, 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	fmt.Fprintln(w, "first message")
+	if _, err := fmt.Fprintln(w, "second message"); err == nil {
+		t.Error("Write didn't report the failed rotation")
+	}
+	if _, err := fmt.Fprintln(w, "third message"); err == nil {
+		t.Error("Write didn't report the failed rotation")
+	}
+
+	// once the backup can be created, the next write rotates
+	os.RemoveAll(filename + ".1")
+	if _, err := fmt.Fprintln(w, "fourth message"); err != nil {
+		t.Fatalf("Write after the rotation was fixed: %v", err)
+	}
+
+	data, _ := ioutil.ReadFile(filename + ".1")
+	if want := "first message\nsecond message\nthird message\n"; string(data) != want {
+		t.Errorf("backup contains %q, want %q", data, want)
+	}
+	data, _ = ioutil.ReadFile(filename)
+	if string(data) != "fourth message\n" {
+		t.Errorf("current file contains %q", data)
+	}
+}
@@ -0,0 +1,68 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampler suppresses repetitive messages: per interval, it lets the first messages with the same level
+// and text through, and after that only every thereafter-th one. The counts start over every interval.
+// Sampling is counter based, so the same sequence of messages is always sampled the same way. There is
+// no random source to seed: tests can predict exactly which messages are written.
+type Sampler struct {
+	mutex      sync.Mutex
+	first      int
+	thereafter int
+	interval   time.Duration
+	start      time.Time // start of the current interval
+	counts     map[sampleKey]int
+	dropped    uint64
+}
+
+type sampleKey struct {
+	level   int
+	message string
+}
+
+// NewSampler creates a Sampler letting through the first messages of each level and text per interval,
+// then every thereafter-th one. A thereafter of 0 drops all messages after the first ones. With a first
+// of 1, the first occurrence of every distinct message in each interval is always written, and only its
+// repeats are sampled, e.g. for error dashboards.
+func NewSampler(first, thereafter int, interval time.Duration) *Sampler {
+	return &Sampler{first: first, thereafter: thereafter, interval: interval, counts: map[sampleKey]int{}}
+}
+
+// allow counts the message and reports whether it is sampled
+func (s *Sampler) allow(level int, message string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now := time.Now(); now.Sub(s.start) >= s.interval {
+		s.start = now
+		s.counts = map[sampleKey]int{}
+	}
+	key := sampleKey{level, message}
+	n := s.counts[key] + 1
+	s.counts[key] = n
+	if n <= s.first || (s.thereafter > 0 && (n-s.first)%s.thereafter == 0) {
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// DroppedCount returns the number of messages dropped by the sampler
+func (s *Sampler) DroppedCount() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dropped
+}
+
+// SetSampler samples the messages at or below the given level with s, e.g. LOG_LEVEL_ERROR to sample
+// everything but fatal messages. Child loggers share the sampler and its counts. A nil sampler turns
+// sampling off.
+func (logger *Logger) SetSampler(level int, s *Sampler) {
+	logger.mutex.Lock()
+	logger.sampler = s
+	logger.sampleLevel = level
+	logger.mutex.Unlock()
+}
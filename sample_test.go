@@ -0,0 +1,84 @@
+package log_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestSampler(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	sampler := log.NewSampler(10, 100, time.Hour)
+	logger.SetSampler(log.LOG_LEVEL_WARN, sampler)
+
+	for i := 0; i < 1000; i++ {
+		logger.Warn("connection refused")
+	}
+	logger.Warn("another warning")
+	logger.Error("not sampled")
+
+	// the first 10 messages, then the 110th, 210th, ... 910th
+	repeated := 0
+	for _, line := range rec.Lines() {
+		if strings.HasSuffix(line, ": connection refused\n") {
+			repeated++
+		}
+	}
+	if repeated != 19 || len(rec.Lines()) != 21 {
+		t.Errorf("wrote %d lines with %d of 1000 repeated messages, want 21 with 19", len(rec.Lines()), repeated)
+	}
+	if d := sampler.DroppedCount(); d != 981 {
+		t.Errorf("DroppedCount = %d, want 981", d)
+	}
+}
+
+func TestSamplerFirstOccurrence(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	logger.SetSampler(log.LOG_LEVEL_ERROR, log.NewSampler(1, 5, 50*time.Millisecond))
+
+	// two distinct errors interleaved, the first occurrence of each is kept
+	for i := 0; i < 10; i++ {
+		logger.Error("disk full")
+		logger.Error("connection refused")
+	}
+	lines := rec.Lines()
+	if len(lines) != 4 || !strings.HasSuffix(lines[0], ": disk full\n") || !strings.HasSuffix(lines[1], ": connection refused\n") {
+		t.Fatalf("wrote %q, want both first occurrences followed by the 6th repeat of each", lines)
+	}
+
+	// in the next window, the first occurrences are kept again
+	time.Sleep(60 * time.Millisecond)
+	logger.Error("connection refused")
+	logger.Error("disk full")
+	if lines := rec.Lines()[4:]; len(lines) != 2 || !strings.HasSuffix(lines[0], ": connection refused\n") || !strings.HasSuffix(lines[1], ": disk full\n") {
+		t.Errorf("wrote %q in the next window, want both first occurrences", lines)
+	}
+}
+
+func TestSamplerDeterministic(t *testing.T) {
+	var runs [2][]string
+	for run := range runs {
+		rec := &lineRecorder{}
+		logger := log.New(rec, log.LOG_LEVEL_INFO)
+		logger.SetSampler(log.LOG_LEVEL_ERROR, log.NewSampler(2, 3, time.Hour))
+		// each message is logged 10 times, the 1st, 2nd, 5th and 8th pass
+		for i := 0; i < 30; i++ {
+			logger.Infof("message %d", i%3)
+		}
+		for _, line := range rec.Lines() {
+			runs[run] = append(runs[run], line[strings.LastIndex(line, ": ")+2:])
+		}
+	}
+	if strings.Join(runs[0], "") != strings.Join(runs[1], "") || len(runs[0]) != 3*4 {
+		t.Errorf("runs sampled %q and %q, want the same 12 messages", runs[0], runs[1])
+	}
+	// the sampled lines are known in advance: the 4 passing rounds of all three messages
+	want := strings.Repeat("message 0\nmessage 1\nmessage 2\n", 4)
+	if got := strings.Join(runs[0], ""); got != want {
+		t.Errorf("sampled %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// raise re-sends sig to the current process after the logger has been shut down, so the program still
+// terminates the way it would without the handler. Tests replace it to observe the signal.
+var raise = func(sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		p.Signal(sig)
+	}
+}
+
+// HandleShutdownSignals installs a handler that flushes and closes the logger when one of the given
+// signals arrives (SIGINT and SIGTERM if none are given) and then re-raises the signal. Flushing gives up
+// after timeout so a hung writer can't prevent the program from exiting. The returned function removes
+// the handler.
+func (logger *Logger) HandleShutdownSignals(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	done := make(chan int)
+	go logger.handleShutdown(ch, done, timeout, func(sig os.Signal) {
+		// restore the default behavior before re-raising the signal
+		signal.Stop(ch)
+		raise(sig)
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// handleShutdown waits for a signal on ch, shuts the logger down and calls reraise with the signal.
+// It returns without doing anything once done is closed.
+func (logger *Logger) handleShutdown(ch <-chan os.Signal, done <-chan int, timeout time.Duration, reraise func(os.Signal)) {
+	select {
+	case sig := <-ch:
+		logger.shutdown(timeout)
+		reraise(sig)
+	case <-done:
+	}
+}
+
+// shutdown flushes and closes the logger, waiting at most timeout for it to finish
+func (logger *Logger) shutdown(timeout time.Duration) {
+	finished := make(chan int)
+	go func() {
+		flushWriter(logger.Writer())
+		logger.Close()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+	}
+}
@@ -0,0 +1,144 @@
+package log
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// std holds the *Logger used by the package level logging functions, stderr at INFO by default
+var std atomic.Value
+
+func init() {
+	std.Store(New(os.Stderr, LOG_LEVEL_INFO))
+}
+
+// Default returns the logger used by the package level logging functions
+func Default() *Logger {
+	return std.Load().(*Logger)
+}
+
+// SetDefault makes the package level logging functions write to logger. The swap is atomic, calls
+// running concurrently finish with the logger they started with. The previous logger is not closed.
+func SetDefault(logger *Logger) {
+	std.Store(logger)
+}
+
+// Print logs a message at log level LOG_LEVEL_INFO with the default logger
+func Print(v ...interface{}) {
+	Default().Print(v...)
+}
+
+// Printf logs a formatted message at log level LOG_LEVEL_INFO with the default logger
+func Printf(format string, v ...interface{}) {
+	Default().Printf(format, v...)
+}
+
+// Println logs a message, like fmt.Sprintln, at log level LOG_LEVEL_INFO with the default logger
+func Println(v ...interface{}) {
+	Default().Println(v...)
+}
+
+// Trace logs a message at log level LOG_LEVEL_TRACE with the default logger
+func Trace(v ...interface{}) {
+	Default().Trace(v...)
+}
+
+// Tracef logs a formatted message at log level LOG_LEVEL_TRACE with the default logger
+func Tracef(format string, v ...interface{}) {
+	Default().Tracef(format, v...)
+}
+
+// Traceln logs a message, like fmt.Sprintln, at log level LOG_LEVEL_TRACE with the default logger
+func Traceln(v ...interface{}) {
+	Default().Traceln(v...)
+}
+
+// Debug logs a message at log level LOG_LEVEL_DEBUG with the default logger
+func Debug(v ...interface{}) {
+	Default().Debug(v...)
+}
+
+// Debugf logs a formatted message at log level LOG_LEVEL_DEBUG with the default logger
+func Debugf(format string, v ...interface{}) {
+	Default().Debugf(format, v...)
+}
+
+// Debugln logs a message, like fmt.Sprintln, at log level LOG_LEVEL_DEBUG with the default logger
+func Debugln(v ...interface{}) {
+	Default().Debugln(v...)
+}
+
+// Info logs a message at log level LOG_LEVEL_INFO with the default logger
+func Info(v ...interface{}) {
+	Default().Info(v...)
+}
+
+// Infof logs a formatted message at log level LOG_LEVEL_INFO with the default logger
+func Infof(format string, v ...interface{}) {
+	Default().Infof(format, v...)
+}
+
+// Infoln logs a message, like fmt.Sprintln, at log level LOG_LEVEL_INFO with the default logger
+func Infoln(v ...interface{}) {
+	Default().Infoln(v...)
+}
+
+// Warn logs a message at log level LOG_LEVEL_WARN with the default logger
+func Warn(v ...interface{}) {
+	Default().Warn(v...)
+}
+
+// Warnf logs a formatted message at log level LOG_LEVEL_WARN with the default logger
+func Warnf(format string, v ...interface{}) {
+	Default().Warnf(format, v...)
+}
+
+// Warnln logs a message, like fmt.Sprintln, at log level LOG_LEVEL_WARN with the default logger
+func Warnln(v ...interface{}) {
+	Default().Warnln(v...)
+}
+
+// Error logs a message at log level LOG_LEVEL_ERROR with the default logger
+func Error(v ...interface{}) {
+	Default().Error(v...)
+}
+
+// Errorf logs a formatted message at log level LOG_LEVEL_ERROR with the default logger
+func Errorf(format string, v ...interface{}) {
+	Default().Errorf(format, v...)
+}
+
+// Errorln logs a message, like fmt.Sprintln, at log level LOG_LEVEL_ERROR with the default logger
+func Errorln(v ...interface{}) {
+	Default().Errorln(v...)
+}
+
+// Fatal logs a message at log level LOG_LEVEL_FATAL with the default logger, then exits
+func Fatal(v ...interface{}) {
+	Default().Fatal(v...)
+}
+
+// Fatalf logs a formatted message at log level LOG_LEVEL_FATAL with the default logger, then exits
+func Fatalf(format string, v ...interface{}) {
+	Default().Fatalf(format, v...)
+}
+
+// Fatalln logs a message, like fmt.Sprintln, at log level LOG_LEVEL_FATAL with the default logger, then exits
+func Fatalln(v ...interface{}) {
+	Default().Fatalln(v...)
+}
+
+// Panic logs a message at log level LOG_LEVEL_FATAL with the default logger, then panics
+func Panic(v ...interface{}) {
+	Default().Panic(v...)
+}
+
+// Panicf logs a formatted message at log level LOG_LEVEL_FATAL with the default logger, then panics
+func Panicf(format string, v ...interface{}) {
+	Default().Panicf(format, v...)
+}
+
+// Panicln logs a message, like fmt.Sprintln, at log level LOG_LEVEL_FATAL with the default logger, then panics
+func Panicln(v ...interface{}) {
+	Default().Panicln(v...)
+}
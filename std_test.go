@@ -0,0 +1,45 @@
+package log_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	log "."
+)
+
+func TestDefaultLogger(t *testing.T) {
+	prev := log.Default()
+	defer log.SetDefault(prev)
+
+	rec := &lineRecorder{}
+	log.SetDefault(log.New(rec, log.LOG_LEVEL_DEBUG))
+	log.Debugf("debug %d", 1)
+	log.Printf("print %s", "f")
+	log.Error("error")
+
+	lines := rec.Lines()
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "DEBUG: ") || !strings.HasSuffix(lines[1], ": print f\n") || !strings.HasPrefix(lines[2], "ERROR: ") {
+		t.Errorf("default logger wrote %q", lines)
+	}
+
+	// concurrent logging and swapping is safe
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.Info("concurrent")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			log.SetDefault(log.New(rec, log.LOG_LEVEL_INFO))
+		}()
+	}
+	wg.Wait()
+	if n := len(rec.Lines()); n != 403 {
+		t.Errorf("%d lines written, want 403", n)
+	}
+}
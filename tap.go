@@ -0,0 +1,95 @@
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TAP_BUFFER_SIZE is the number of messages a tap buffers before it drops new ones
+const TAP_BUFFER_SIZE = 100
+
+// tap receives a copy of the messages at or above its level
+type tap struct {
+	level int
+	ch    chan string
+}
+
+// tapSet holds the taps of a logger and its WithFields children
+type tapSet struct {
+	mutex    sync.RWMutex
+	taps     []*tap
+	minLevel int32 // lowest level of the taps, 0 without taps, accessed atomically
+}
+
+// Tap attaches a tap receiving a copy of every formatted message at or above minLevel, including the
+// messages of WithFields children, until the returned detach function is called. Messages below the
+// log level of the logger are passed to the tap too, so a tap can watch debug messages in production
+// without writing them. The tap never blocks logging: if its buffer of TAP_BUFFER_SIZE messages is
+// full, new messages are dropped. Detaching closes the channel.
+func (logger *Logger) Tap(minLevel int) (<-chan string, func()) {
+	t := &tap{level: minLevel, ch: make(chan string, TAP_BUFFER_SIZE)}
+	s := logger.taps
+	s.mutex.Lock()
+	s.taps = append(s.taps, t)
+	s.update()
+	s.mutex.Unlock()
+
+	var once sync.Once
+	return t.ch, func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			for i, x := range s.taps {
+				if x == t {
+					s.taps = append(s.taps[:i:i], s.taps[i+1:]...)
+					break
+				}
+			}
+			s.update()
+			close(t.ch)
+			s.mutex.Unlock()
+		})
+	}
+}
+
+// update recomputes the lowest level of the taps. The mutex must be held.
+func (s *tapSet) update() {
+	min := 0
+	for _, t := range s.taps {
+		if min == 0 || t.level < min {
+			min = t.level
+		}
+	}
+	atomic.StoreInt32(&s.minLevel, int32(min))
+}
+
+// active reports whether any tap is attached
+func (s *tapSet) active() bool {
+	return s != nil && atomic.LoadInt32(&s.minLevel) != 0
+}
+
+// wants reports whether a tap is attached for messages at the given level
+func (s *tapSet) wants(level int) bool {
+	if s == nil {
+		return false
+	}
+	min := int(atomic.LoadInt32(&s.minLevel))
+	return min != 0 && level >= min
+}
+
+// send passes a message to the taps for its level, dropping it for taps that are full
+func (s *tapSet) send(level int, msg string) {
+	if !s.wants(level) {
+		return
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, t := range s.taps {
+		if level < t.level {
+			continue
+		}
+		select {
+		case t.ch <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package log_test
+
+import (
+	"strings"
+	"testing"
+
+	log "."
+)
+
+func TestTap(t *testing.T) {
+	rec := &lineRecorder{}
+	logger := log.New(rec, log.LOG_LEVEL_INFO)
+	ch, detach := logger.Tap(log.LOG_LEVEL_DEBUG)
+
+	logger.Trace("below the tap")
+	logger.Debug("tapped only")
+	logger.WithFields(log.Fields{"k": "v"}).Warn("tapped and written")
+
+	if msg := <-ch; !strings.HasPrefix(msg, "DEBUG: ") || !strings.HasSuffix(msg, ": tapped only\n") {
+		t.Errorf("tap received %q", msg)
+	}
+	if msg := <-ch; !strings.HasSuffix(msg, ": tapped and written k=v\n") {
+		t.Errorf("tap received %q", msg)
+	}
+	if lines := rec.Lines(); len(lines) != 1 || !strings.HasPrefix(lines[0], "WARN: ") {
+		t.Errorf("writer received %q, want only the WARN message", lines)
+	}
+
+	detach()
+	detach()
+	logger.Warn("after detaching")
+	if msg, ok := <-ch; ok {
+		t.Errorf("detached tap received %q", msg)
+	}
+	logger.Debug("filtered again")
+	if n := len(rec.Lines()); n != 2 {
+		t.Errorf("writer received %d lines, want 2", n)
+	}
+
+	// a full tap drops messages instead of blocking
+	_, detach = logger.Tap(log.LOG_LEVEL_INFO)
+	defer detach()
+	for i := 0; i < 2*log.TAP_BUFFER_SIZE; i++ {
+		logger.Info("flood")
+	}
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ThrottleWriter caps the number of bytes written to the wrapped writer per time window, to protect
+// against runaway log volume and its cost. Messages beyond the budget are dropped whole and counted
+// until the next window starts.
+type ThrottleWriter struct {
+	mutex   sync.Mutex
+	w       io.Writer
+	budget  int
+	window  time.Duration
+	start   time.Time // start of the current window
+	used    int       // bytes written in the current window
+	dropped uint64
+}
+
+// NewThrottleWriter creates a ThrottleWriter writing at most maxBytes to w per window
+func NewThrottleWriter(w io.Writer, maxBytes int, window time.Duration) *ThrottleWriter {
+	return &ThrottleWriter{w: w, budget: maxBytes, window: window}
+}
+
+func (w *ThrottleWriter) Write(data []byte) (n int, err error) {
+	return w.WriteLevel(0, data)
+}
+
+// WriteLevel is Write for a message at the given level, which is passed on if the wrapped writer is a
+// LevelWriter
+func (w *ThrottleWriter) WriteLevel(level int, data []byte) (n int, err error) {
+	w.mutex.Lock()
+	now := time.Now()
+	if now.Sub(w.start) >= w.window {
+		w.start = now
+		w.used = 0
+	}
+	if w.used+len(data) > w.budget {
+		w.dropped++
+		w.mutex.Unlock()
+		return len(data), nil
+	}
+	w.used += len(data)
+	w.mutex.Unlock()
+
+	return writeLevel(w.w, level, data)
+}
+
+// DroppedCount returns the number of messages dropped because the budget was used up
+func (w *ThrottleWriter) DroppedCount() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.dropped
+}
+
+// Flush flushes the wrapped writer if it implements Flusher
+func (w *ThrottleWriter) Flush() error {
+	return flushWriter(w.w)
+}
+
+// Sync syncs the wrapped writer if it implements Syncer
+func (w *ThrottleWriter) Sync() error {
+	return syncWriter(w.w)
+}
+
+// Close closes the wrapped writer if it can be closed
+func (w *ThrottleWriter) Close() error {
+	return closeInner(w.w)
+}
@@ -0,0 +1,31 @@
+package log_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	log "."
+)
+
+func TestThrottleWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := log.NewThrottleWriter(&buf, 25, 50*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		if n, err := w.Write([]byte("ten bytes\n")); n != 10 || err != nil {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+	}
+	if buf.String() != strings.Repeat("ten bytes\n", 2) || w.DroppedCount() != 3 {
+		t.Errorf("wrote %q and dropped %d messages, want 2 written and 3 dropped", buf.String(), w.DroppedCount())
+	}
+
+	// the budget is restored in the next window
+	time.Sleep(60 * time.Millisecond)
+	w.Write([]byte("ten bytes\n"))
+	if strings.Count(buf.String(), "\n") != 3 {
+		t.Errorf("nothing written in the next window: %q", buf.String())
+	}
+}